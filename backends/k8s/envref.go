@@ -0,0 +1,138 @@
+package k8s
+
+import (
+	"fmt"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+
+	"github.com/plumber-cd/runtainer/log"
+	"github.com/spf13/viper"
+)
+
+// fieldPaths are the shortcuts for the downward API fields, anything else is used as-is
+var fieldPaths = map[string]string{
+	"podName":   "metadata.name",
+	"namespace": "metadata.namespace",
+	"nodeName":  "spec.nodeName",
+	"podIP":     "status.podIP",
+	"hostIP":    "status.hostIP",
+}
+
+// parseEnvRef parses NAME=ref[:optional] and returns its parts
+func parseEnvRef(flag, input string) (name, ref string, optional bool, err error) {
+	log.Debug.Printf("Parsing --%s=%s", flag, input)
+	split := strings.SplitN(input, "=", 2)
+	if len(split) != 2 || split[0] == "" || split[1] == "" {
+		return "", "", false, fmt.Errorf("Invalid input for --%s=%s", flag, input)
+	}
+	name = split[0]
+
+	cfg := strings.Split(split[1], ":")
+	ref = cfg[0]
+	for _, option := range cfg[1:] {
+		switch option {
+		case "optional":
+			optional = true
+		case "required":
+			optional = false
+		default:
+			return "", "", false, fmt.Errorf("Invalid option %q for --%s=%s", option, flag, input)
+		}
+	}
+
+	return name, ref, optional, nil
+}
+
+// parseKeyRef splits name/key reference
+func parseKeyRef(flag, input, ref string) (string, string, error) {
+	split := strings.SplitN(ref, "/", 2)
+	if len(split) != 2 || split[0] == "" || split[1] == "" {
+		return "", "", fmt.Errorf("Invalid input for --%s=%s, expected NAME=name/key", flag, input)
+	}
+	return split[0], split[1], nil
+}
+
+// setEnv adds the env variable to the container, replacing the one with the same name if it was already there
+func setEnv(containerSpec *v1.Container, envVar v1.EnvVar) {
+	for i, e := range containerSpec.Env {
+		if e.Name == envVar.Name {
+			log.Info.Printf("Env variable %s overridden by a reference", envVar.Name)
+			containerSpec.Env[i] = envVar
+			return
+		}
+	}
+	containerSpec.Env = append(containerSpec.Env, envVar)
+}
+
+// addEnvRefs adds env variables referencing single secret keys, config map keys and downward API fields
+func addEnvRefs(containerSpec *v1.Container) error {
+	for _, input := range viper.GetStringSlice("secrets.envKeys") {
+		name, ref, optional, err := parseEnvRef("secret-env-key", input)
+		if err != nil {
+			return err
+		}
+		secret, key, err := parseKeyRef("secret-env-key", input, ref)
+		if err != nil {
+			return err
+		}
+		log.Info.Printf("Adding env variable %s from secret %s key %s (optional=%t)", name, secret, key, optional)
+		setEnv(containerSpec, v1.EnvVar{
+			Name: name,
+			ValueFrom: &v1.EnvVarSource{
+				SecretKeyRef: &v1.SecretKeySelector{
+					LocalObjectReference: v1.LocalObjectReference{
+						Name: secret,
+					},
+					Key:      key,
+					Optional: ptr(optional),
+				},
+			},
+		})
+	}
+
+	for _, input := range viper.GetStringSlice("configmaps.envKeys") {
+		name, ref, optional, err := parseEnvRef("configmap-env-key", input)
+		if err != nil {
+			return err
+		}
+		configMap, key, err := parseKeyRef("configmap-env-key", input, ref)
+		if err != nil {
+			return err
+		}
+		log.Info.Printf("Adding env variable %s from config map %s key %s (optional=%t)", name, configMap, key, optional)
+		setEnv(containerSpec, v1.EnvVar{
+			Name: name,
+			ValueFrom: &v1.EnvVarSource{
+				ConfigMapKeyRef: &v1.ConfigMapKeySelector{
+					LocalObjectReference: v1.LocalObjectReference{
+						Name: configMap,
+					},
+					Key:      key,
+					Optional: ptr(optional),
+				},
+			},
+		})
+	}
+
+	for _, input := range viper.GetStringSlice("fieldEnv") {
+		name, field, _, err := parseEnvRef("field-env", input)
+		if err != nil {
+			return err
+		}
+		if path, ok := fieldPaths[field]; ok {
+			field = path
+		}
+		log.Info.Printf("Adding env variable %s from field %s", name, field)
+		setEnv(containerSpec, v1.EnvVar{
+			Name: name,
+			ValueFrom: &v1.EnvVarSource{
+				FieldRef: &v1.ObjectFieldSelector{
+					FieldPath: field,
+				},
+			},
+		})
+	}
+
+	return nil
+}
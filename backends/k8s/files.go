@@ -0,0 +1,198 @@
+package k8s
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/plumber-cd/runtainer/env"
+	"github.com/plumber-cd/runtainer/host"
+	"github.com/plumber-cd/runtainer/log"
+	"github.com/spf13/viper"
+)
+
+var invalidKeyChars = regexp.MustCompile(`[^-._a-zA-Z0-9]`)
+
+// ephemeralFile is a host file to be uploaded and mounted into the container
+type ephemeralFile struct {
+	key  string
+	dest string
+	data []byte
+}
+
+// readEphemeralFiles parses src:dest pairs and reads the files from the host, relative src is resolved against cwd
+func readEphemeralFiles(flag, cwd string, pairs []string) ([]ephemeralFile, error) {
+	files := []ephemeralFile{}
+	for i, pair := range pairs {
+		log.Debug.Printf("Parsing --%s=%s", flag, pair)
+		// src might be a windows path with the drive letter, while dest is always a container path without colons
+		split := strings.LastIndex(pair, ":")
+		if split <= 0 || split == len(pair)-1 {
+			return nil, fmt.Errorf("Invalid input for --%s=%s", flag, pair)
+		}
+		src, dest := pair[:split], pair[split+1:]
+		if !filepath.IsAbs(src) {
+			src = filepath.Join(cwd, src)
+		}
+
+		data, err := os.ReadFile(src)
+		if err != nil {
+			return nil, err
+		}
+
+		files = append(files, ephemeralFile{
+			key:  fmt.Sprintf("file-%d-%s", i, invalidKeyChars.ReplaceAllString(filepath.Base(src), "_")),
+			dest: dest,
+			data: data,
+		})
+	}
+	return files, nil
+}
+
+// readEphemeralEnvFiles reads and merges dotenv files, later files override earlier ones.
+// Relative paths are resolved against cwd.
+func readEphemeralEnvFiles(flag, cwd string, paths []string) (map[string]string, error) {
+	merged := map[string]string{}
+	for _, path := range paths {
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(cwd, path)
+		}
+		log.Debug.Printf("Parsing --%s=%s", flag, path)
+		vars, err := env.ParseDotEnvFile(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range vars {
+			merged[v.Name] = v.Value
+		}
+	}
+	return merged, nil
+}
+
+// mountEphemeralFiles mounts every file from the volume with subPath
+func mountEphemeralFiles(containerSpec *v1.Container, volumeName string, files []ephemeralFile) {
+	for _, f := range files {
+		log.Info.Printf("Mounting %s from %s to %s", f.key, volumeName, f.dest)
+		containerSpec.VolumeMounts = append(containerSpec.VolumeMounts, v1.VolumeMount{
+			Name:      volumeName,
+			MountPath: f.dest,
+			SubPath:   f.key,
+			ReadOnly:  true,
+		})
+	}
+}
+
+// addEphemeralFiles uploads host files and env files into ephemeral secrets and config maps, and wires them into the pod.
+// This is for the files that the cluster node can't see via hostPath.
+// Relative host paths are resolved against the host working directory, same as --env-file.
+func addEphemeralFiles(cwd string, podOptions *host.PodOptions, podSpec *v1.Pod, containerSpec *v1.Container) error {
+	podName := podSpec.ObjectMeta.Name
+	namespace := podSpec.ObjectMeta.Namespace
+
+	files, err := readEphemeralFiles("file-secret", cwd, viper.GetStringSlice("secrets.files"))
+	if err != nil {
+		return err
+	}
+	if len(files) > 0 {
+		secret := &v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: podName + "-files", Namespace: namespace},
+			Type:       v1.SecretTypeOpaque,
+			Data:       map[string][]byte{},
+		}
+		for _, f := range files {
+			secret.Data[f.key] = f.data
+		}
+		podOptions.Secrets = append(podOptions.Secrets, secret)
+
+		podSpec.Spec.Volumes = append(podSpec.Spec.Volumes, v1.Volume{
+			Name: secret.ObjectMeta.Name,
+			VolumeSource: v1.VolumeSource{
+				Secret: &v1.SecretVolumeSource{
+					SecretName:  secret.ObjectMeta.Name,
+					DefaultMode: ptr(int32(0600)), // since we use fsGroup - it will result in 0640 in reality
+				},
+			},
+		})
+		mountEphemeralFiles(containerSpec, secret.ObjectMeta.Name, files)
+	}
+
+	files, err = readEphemeralFiles("file-configmap", cwd, viper.GetStringSlice("configmaps.files"))
+	if err != nil {
+		return err
+	}
+	if len(files) > 0 {
+		configMap := &v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: podName + "-files", Namespace: namespace},
+			Data:       map[string]string{},
+			BinaryData: map[string][]byte{},
+		}
+		for _, f := range files {
+			if utf8.Valid(f.data) {
+				configMap.Data[f.key] = string(f.data)
+			} else {
+				configMap.BinaryData[f.key] = f.data
+			}
+		}
+		podOptions.ConfigMaps = append(podOptions.ConfigMaps, configMap)
+
+		podSpec.Spec.Volumes = append(podSpec.Spec.Volumes, v1.Volume{
+			Name: configMap.ObjectMeta.Name + "-cm",
+			VolumeSource: v1.VolumeSource{
+				ConfigMap: &v1.ConfigMapVolumeSource{
+					LocalObjectReference: v1.LocalObjectReference{Name: configMap.ObjectMeta.Name},
+				},
+			},
+		})
+		mountEphemeralFiles(containerSpec, configMap.ObjectMeta.Name+"-cm", files)
+	}
+
+	vars, err := readEphemeralEnvFiles("env-file-secret", cwd, viper.GetStringSlice("secrets.envFiles"))
+	if err != nil {
+		return err
+	}
+	if len(vars) > 0 {
+		secret := &v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: podName + "-env-files", Namespace: namespace},
+			Type:       v1.SecretTypeOpaque,
+			StringData: vars,
+		}
+		for _, v := range vars {
+			log.AddSecret(v)
+		}
+		podOptions.Secrets = append(podOptions.Secrets, secret)
+
+		log.Info.Printf("Adding env envFrom ephemeral secret: %s", secret.ObjectMeta.Name)
+		containerSpec.EnvFrom = append(containerSpec.EnvFrom, v1.EnvFromSource{
+			SecretRef: &v1.SecretEnvSource{
+				LocalObjectReference: v1.LocalObjectReference{Name: secret.ObjectMeta.Name},
+			},
+		})
+	}
+
+	vars, err = readEphemeralEnvFiles("env-file-configmap", cwd, viper.GetStringSlice("configmaps.envFiles"))
+	if err != nil {
+		return err
+	}
+	if len(vars) > 0 {
+		configMap := &v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: podName + "-env-files", Namespace: namespace},
+			Data:       vars,
+		}
+		podOptions.ConfigMaps = append(podOptions.ConfigMaps, configMap)
+
+		log.Info.Printf("Adding env envFrom ephemeral config map: %s", configMap.ObjectMeta.Name)
+		containerSpec.EnvFrom = append(containerSpec.EnvFrom, v1.EnvFromSource{
+			ConfigMapRef: &v1.ConfigMapEnvSource{
+				LocalObjectReference: v1.LocalObjectReference{Name: configMap.ObjectMeta.Name},
+			},
+		})
+	}
+
+	return nil
+}
@@ -2,7 +2,10 @@ package k8s
 
 import (
 	"bytes"
+	"context"
 	"fmt"
+	"io"
+	"math"
 	"os"
 	"runtime"
 	"strings"
@@ -12,11 +15,12 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime/serializer/json"
 	"k8s.io/client-go/kubernetes/scheme"
-	"k8s.io/client-go/util/exec"
 
-	"github.com/plumber-cd/runtainer/discover"
+	"github.com/plumber-cd/runtainer/env"
+	"github.com/plumber-cd/runtainer/failure"
 	"github.com/plumber-cd/runtainer/host"
 	"github.com/plumber-cd/runtainer/log"
+	"github.com/plumber-cd/runtainer/spec"
 	"github.com/plumber-cd/runtainer/utils"
 	"github.com/spf13/viper"
 )
@@ -25,16 +29,89 @@ func ptr[T any](v T) *T {
 	return &v
 }
 
-func Run(containerCmd, containerArgs []string) {
+// Streams to connect the container to, nil fields default to the standard streams
+type Streams struct {
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// Run runs the container as described by the spec.
+// Returns pod options it was using, so that the caller could inspect what was run,
+// and an error as it was returned by host.ExecPod.
+func Run(ctx context.Context, s *spec.RunSpec, streams Streams, containerCmd, containerArgs []string) (*host.PodOptions, error) {
+	log.SetPhase("run")
 	log.Debug.Print("Starting k8s backend")
 
-	stdIn, stdOut, stdErr := term.StdStreams()
+	podOptions, podSpecJson, err := NewPodOptions(s, streams, containerCmd, containerArgs)
+	if err != nil {
+		return nil, err
+	}
+	podOptions.Context = ctx
+	if viper.GetBool("dry-run") {
+		log.Debug.Print("--dry-run mode enabled")
+		fmt.Fprintln(podOptions.Stdout, log.Redact(podSpecJson))
+		return podOptions, nil
+	}
 
-	h, e, p, i, v := discover.GetFromViper()
+	if path := viper.GetString("record"); path != "" {
+		log.Debug.Print("--record mode enabled")
+		title := strings.Join(append([]string{"runtainer", podOptions.PodSpec.Spec.Containers[0].Image}, append(containerCmd, containerArgs...)...), " ")
+		recorder, err := host.NewCastRecorder(path, title, viper.GetBool("record-input"))
+		if err != nil {
+			return podOptions, err
+		}
+		defer func() {
+			if err := recorder.Close(); err != nil {
+				log.Error.Print(err)
+			}
+		}()
+		podOptions.Recorder = recorder
+	}
+
+	return podOptions, host.ExecPod(podOptions)
+}
+
+// redactSecret returns a copy of the secret with every value masked, unless --show-secrets was used.
+// Values are base64 encoded in the output, so redaction of the output text would never catch them.
+func redactSecret(secret *v1.Secret) *v1.Secret {
+	if log.ShowSecrets() {
+		return secret
+	}
+
+	masked := secret.DeepCopy()
+	masked.Data = nil
+	masked.StringData = map[string]string{}
+	for key := range secret.Data {
+		masked.StringData[key] = log.Redacted
+	}
+	for key := range secret.StringData {
+		masked.StringData[key] = log.Redacted
+	}
+	return masked
+}
+
+// NewPodOptions builds pod spec and run options out of the spec.
+// Along with the options it returns a YAML representation of the pod spec.
+func NewPodOptions(s *spec.RunSpec, streams Streams, containerCmd, containerArgs []string) (*host.PodOptions, string, error) {
+	var stdIn io.Reader
+	var stdOut, stdErr io.Writer
+	stdIn, stdOut, stdErr = term.StdStreams()
+	if streams.Stdin != nil {
+		stdIn = streams.Stdin
+	}
+	if streams.Stdout != nil {
+		stdOut = streams.Stdout
+	}
+	if streams.Stderr != nil {
+		stdErr = streams.Stderr
+	}
+
+	h, e, p, i, v := s.Host, s.Env, s.Ports, s.Image, s.Volumes
 
 	kubeconfig, clientset, namespace, err := host.GetKubeClient()
 	if err != nil {
-		log.Normal.Panic(err)
+		return nil, "", err
 	}
 
 	podName := fmt.Sprintf("runtainer-%s", utils.RandomHex(4))
@@ -65,14 +142,24 @@ func Run(containerCmd, containerArgs []string) {
 		},
 	}
 	podOptions := host.PodOptions{
-		Config:    kubeconfig,
-		Clientset: clientset,
-		Namespace: namespace,
-		PodSpec:   &podSpec,
-		Container: containerName,
-		Mode:      host.PodRunModeModeAttach,
-		Stdout:    stdOut,
-		Stderr:    stdErr,
+		Config:         kubeconfig,
+		Clientset:      clientset,
+		Namespace:      namespace,
+		PodSpec:        &podSpec,
+		Container:      containerName,
+		Mode:           host.PodRunModeModeAttach,
+		Stdout:         stdOut,
+		Stderr:         stdErr,
+		StartupTimeout: viper.GetDuration("startup-timeout"),
+		Timeout:        viper.GetDuration("timeout"),
+		ProgressOut:    stdErr,
+	}
+
+	if podOptions.Timeout > 0 {
+		log.Debug.Printf("--timeout %s enabled", podOptions.Timeout)
+		// in case we won't be around to delete the pod in time, the cluster will stop it
+		deadline := int64(math.Ceil(podOptions.Timeout.Seconds()))
+		podSpec.Spec.ActiveDeadlineSeconds = &deadline
 	}
 
 	if secret := viper.GetString("secret"); secret != "" {
@@ -94,13 +181,62 @@ func Run(containerCmd, containerArgs []string) {
 		podSpec.Spec.SecurityContext.FSGroup = &h.GID
 	}
 
+	var envSecret *v1.Secret
+	if viper.GetBool("ephemeral-secrets") {
+		log.Debug.Print("--ephemeral-secrets enabled")
+		envSecret = &v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      podName + "-env",
+				Namespace: namespace,
+			},
+			Type:       v1.SecretTypeOpaque,
+			StringData: map[string]string{},
+		}
+	}
+
 	for key, val := range e {
 		var str string
-		if val == nil {
+		switch v := val.(type) {
+		case nil:
 			str = os.Getenv(key)
-		} else {
-			str = val.(string)
+		case string:
+			str = v
+		case env.SecretKeyRef:
+			log.Info.Printf("Adding env variable %s from secret %s key %s", key, v.Name, v.Key)
+			containerSpec.Env = append(containerSpec.Env, v1.EnvVar{
+				Name: key,
+				ValueFrom: &v1.EnvVarSource{
+					SecretKeyRef: &v1.SecretKeySelector{
+						LocalObjectReference: v1.LocalObjectReference{
+							Name: v.Name,
+						},
+						Key: v.Key,
+					},
+				},
+			})
+			continue
+		default:
+			return nil, "", failure.Errorf(failure.Config, "Unsupported value type %T for env variable %s", val, key)
 		}
+		log.AddSecretIfSensitive(key, str)
+
+		if envSecret != nil && log.IsSensitive(key) {
+			log.Info.Printf("Adding env variable via ephemeral secret %s: %s", envSecret.ObjectMeta.Name, key)
+			envSecret.StringData[key] = str
+			containerSpec.Env = append(containerSpec.Env, v1.EnvVar{
+				Name: key,
+				ValueFrom: &v1.EnvVarSource{
+					SecretKeyRef: &v1.SecretKeySelector{
+						LocalObjectReference: v1.LocalObjectReference{
+							Name: envSecret.ObjectMeta.Name,
+						},
+						Key: key,
+					},
+				},
+			})
+			continue
+		}
+
 		log.Info.Printf("Adding env variable: %s=%s", key, str)
 		containerSpec.Env = append(containerSpec.Env, v1.EnvVar{
 			Name:  key,
@@ -108,28 +244,19 @@ func Run(containerCmd, containerArgs []string) {
 		})
 	}
 
-	for _, secret := range viper.GetStringSlice("secrets.env") {
-		cfg := strings.Split(secret, ":")
-		secret = cfg[0]
-		log.Info.Printf("Adding env envFrom: %s", secret)
-
-		envFromSource := v1.EnvFromSource{
-			SecretRef: &v1.SecretEnvSource{
-				LocalObjectReference: v1.LocalObjectReference{
-					Name: secret,
-				},
-				Optional: ptr(true),
-			},
-		}
+	if envSecret != nil && len(envSecret.StringData) > 0 {
+		podOptions.Secrets = append(podOptions.Secrets, envSecret)
+	}
 
-		for _, option := range cfg {
-			if strings.HasPrefix(option, "prefix=") {
-				envFromSource.Prefix = strings.SplitN(option, "=", 2)[1]
-				log.Info.Printf("Secret envFrom %s: custom prefix %s", secret, envFromSource.Prefix)
-			}
-		}
+	if err := addEnvRefs(&containerSpec); err != nil {
+		return nil, "", failure.Wrap(failure.Config, err)
+	}
 
-		containerSpec.EnvFrom = append(containerSpec.EnvFrom, envFromSource)
+	for _, secret := range append(viper.GetStringSlice("secrets.env"), s.Secrets.Env...) {
+		addEnvFrom(&containerSpec, parseSourceRef(sourceSecret, secret))
+	}
+	for _, configMap := range viper.GetStringSlice("configmaps.env") {
+		addEnvFrom(&containerSpec, parseSourceRef(sourceConfigMap, configMap))
 	}
 
 	for _, vol := range v.HostMapping {
@@ -141,7 +268,7 @@ func Run(containerCmd, containerArgs []string) {
 			log.Debug.Printf("Since the platform is %s, convert local disks to /mnt", runtime.GOOS)
 			split := strings.SplitN(src, ":\\", 2)
 			if len(split) != 2 {
-				log.Normal.Fatal(fmt.Errorf("Failed to convert windows path %s", src))
+				return nil, "", failure.Errorf(failure.Discovery, "Failed to convert windows path %s", src)
 			}
 			src = fmt.Sprintf("/mnt/%s/%s", strings.ToLower(split[0]), split[1])
 			src = strings.Replace(src, "\\", "/", -1)
@@ -162,45 +289,15 @@ func Run(containerCmd, containerArgs []string) {
 		})
 	}
 
-	for _, secret := range viper.GetStringSlice("secrets.volumes") {
-		cfg := strings.Split(secret, ":")
-		secret = cfg[0]
-		dst := "/rt-secrets/" + secret
-		log.Info.Printf("Adding secret volume %s -> %s", secret, dst)
-
-		volume := v1.Volume{
-			Name: secret,
-			VolumeSource: v1.VolumeSource{
-				Secret: &v1.SecretVolumeSource{
-					SecretName:  secret,
-					DefaultMode: ptr(int32(0600)), // since we use fsGroup - it will result in 0640 in reality
-					Optional:    ptr(true),
-				},
-			},
-		}
-		volumeMount := v1.VolumeMount{
-			Name:      secret,
-			MountPath: dst,
-			ReadOnly:  true,
-		}
-
-		for _, option := range cfg {
-			if strings.HasPrefix(option, "mountPath=") {
-				volumeMount.MountPath = strings.SplitN(option, "=", 2)[1]
-				log.Info.Printf("Secret volume %s: custom mountPath %s", secret, volumeMount.MountPath)
-			} else if strings.HasPrefix(option, "item=") {
-				item := strings.SplitN(option, "=", 2)[1]
-				keyToPath := v1.KeyToPath{
-					Key:  item,
-					Path: item,
-				}
-				volume.VolumeSource.Secret.Items = append(volume.VolumeSource.Secret.Items, keyToPath)
-				log.Info.Printf("Secret volume %s: custom items %v", secret, volume.VolumeSource.Secret.Items)
-			}
-		}
+	for _, secret := range append(viper.GetStringSlice("secrets.volumes"), s.Secrets.Volumes...) {
+		addSourceVolume(&podSpec, &containerSpec, parseSourceRef(sourceSecret, secret))
+	}
+	for _, configMap := range viper.GetStringSlice("configmaps.volumes") {
+		addSourceVolume(&podSpec, &containerSpec, parseSourceRef(sourceConfigMap, configMap))
+	}
 
-		podSpec.Spec.Volumes = append(podSpec.Spec.Volumes, volume)
-		containerSpec.VolumeMounts = append(containerSpec.VolumeMounts, volumeMount)
+	if err := addEphemeralFiles(h.Cwd, &podOptions, &podSpec, &containerSpec); err != nil {
+		return nil, "", failure.Wrap(failure.Config, err)
 	}
 
 	podOptions.Ports = p
@@ -208,8 +305,11 @@ func Run(containerCmd, containerArgs []string) {
 	if len(containerSpec.Command) > 0 {
 		podOptions.Mode = host.PodRunModeModeExec
 		podOptions.ExecCmd = append(containerSpec.Command, containerSpec.Args...)
+		// cat keeps the container alive for the exec as long as its stdin is open,
+		// regardless of whether the host stdin is connected to the exec
 		containerSpec.Command = []string{"cat"}
 		containerSpec.Args = []string{}
+		containerSpec.Stdin = true
 	} else {
 		if viper.GetBool("interactive") {
 			log.Debug.Print("--interactive mode enabled")
@@ -232,28 +332,48 @@ func Run(containerCmd, containerArgs []string) {
 		podOptions.Tty = true
 	}
 
+	if watch := viper.GetStringSlice("watch"); len(watch) > 0 {
+		log.Debug.Printf("--watch mode enabled: %v", watch)
+		if podOptions.Mode != host.PodRunModeModeExec {
+			return nil, "", failure.Errorf(failure.Config, "--watch requires container cmd to be specified")
+		}
+
+		// input can't be shared across multiple runs, Ctrl+C must stop the watch.
+		// Container stdin stays open, the keep-alive cat needs it to survive between runs.
+		podOptions.Stdin = nil
+		podOptions.Tty = false
+
+		podOptions.Watch = &host.WatchOptions{
+			Dir:   h.Cwd,
+			Globs: watch,
+		}
+	}
+
 	podSpec.Spec.Containers = []v1.Container{containerSpec}
+	if err := applyPodPatches(&podSpec, s.PodPatches); err != nil {
+		return nil, "", failure.Wrap(failure.Config, err)
+	}
 
 	podSpecJsonBuf := new(bytes.Buffer)
 	kubeJsonSerializer := json.NewYAMLSerializer(json.DefaultMetaFactory, scheme.Scheme,
 		scheme.Scheme)
+	for _, secret := range podOptions.Secrets {
+		if err := kubeJsonSerializer.Encode(redactSecret(secret), podSpecJsonBuf); err != nil {
+			return nil, "", err
+		}
+		podSpecJsonBuf.WriteString("---\n")
+	}
+	for _, configMap := range podOptions.ConfigMaps {
+		if err := kubeJsonSerializer.Encode(configMap, podSpecJsonBuf); err != nil {
+			return nil, "", err
+		}
+		podSpecJsonBuf.WriteString("---\n")
+	}
 	if err := kubeJsonSerializer.Encode(&podSpec, podSpecJsonBuf); err != nil {
-		log.Normal.Panic(err)
+		return nil, "", err
 	}
 	podSpecJson := podSpecJsonBuf.String()
 	log.Debug.Printf("Pod: %s", podSpecJson)
-	if viper.GetBool("dry-run") {
-		log.Debug.Print("--dry-run mode enabled")
-		fmt.Println(podSpecJson)
-		return
-	}
 
-	if err := host.ExecPod(&podOptions); err != nil {
-		switch e := err.(type) {
-		case exec.CodeExitError:
-			os.Exit(e.ExitStatus())
-		default:
-			log.Normal.Panic(err)
-		}
-	}
+	return &podOptions, podSpecJson, nil
 }
@@ -0,0 +1,34 @@
+package k8s
+
+import (
+	"encoding/json"
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+
+	"github.com/plumber-cd/runtainer/log"
+)
+
+// applyPodPatches applies strategic merge patches, i.e. returned by discovery plugins, in order
+func applyPodPatches(podSpec *v1.Pod, patches []json.RawMessage) error {
+	for _, patch := range patches {
+		log.Info.Printf("Applying pod patch: %s", string(patch))
+
+		original, err := json.Marshal(podSpec)
+		if err != nil {
+			return err
+		}
+		patched, err := strategicpatch.StrategicMergePatch(original, patch, v1.Pod{})
+		if err != nil {
+			return fmt.Errorf("Invalid pod patch %s: %s", string(patch), err)
+		}
+
+		result := v1.Pod{}
+		if err := json.Unmarshal(patched, &result); err != nil {
+			return err
+		}
+		*podSpec = result
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package k8s
+
+import (
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+
+	"github.com/plumber-cd/runtainer/log"
+)
+
+// sourceKind is where envFrom and volumes are coming from
+type sourceKind string
+
+const (
+	sourceSecret    sourceKind = "secret"
+	sourceConfigMap sourceKind = "config map"
+)
+
+// sourceRef is a parsed name[:prefix=PREFIX][:mountPath=PATH][:item=KEY]... reference to a secret or a config map
+type sourceRef struct {
+	kind      sourceKind
+	name      string
+	prefix    string
+	mountPath string
+	items     []string
+}
+
+// parseSourceRef parses the reference, options that don't apply are ignored
+func parseSourceRef(kind sourceKind, input string) sourceRef {
+	cfg := strings.Split(input, ":")
+	ref := sourceRef{kind: kind, name: cfg[0]}
+	for _, option := range cfg[1:] {
+		split := strings.SplitN(option, "=", 2)
+		if len(split) != 2 {
+			continue
+		}
+		switch split[0] {
+		case "prefix":
+			ref.prefix = split[1]
+		case "mountPath":
+			ref.mountPath = split[1]
+		case "item":
+			ref.items = append(ref.items, split[1])
+		}
+	}
+	return ref
+}
+
+// addEnvFrom adds every variable from the optional secret or config map
+func addEnvFrom(containerSpec *v1.Container, ref sourceRef) {
+	log.Info.Printf("Adding env envFrom %s %s", ref.kind, ref.name)
+	if ref.prefix != "" {
+		log.Info.Printf("Env envFrom %s %s: custom prefix %s", ref.kind, ref.name, ref.prefix)
+	}
+
+	envFromSource := v1.EnvFromSource{Prefix: ref.prefix}
+	reference := v1.LocalObjectReference{Name: ref.name}
+	switch ref.kind {
+	case sourceSecret:
+		envFromSource.SecretRef = &v1.SecretEnvSource{LocalObjectReference: reference, Optional: ptr(true)}
+	case sourceConfigMap:
+		envFromSource.ConfigMapRef = &v1.ConfigMapEnvSource{LocalObjectReference: reference, Optional: ptr(true)}
+	}
+
+	containerSpec.EnvFrom = append(containerSpec.EnvFrom, envFromSource)
+}
+
+// addSourceVolume mounts the optional secret or config map read-only,
+// to /rt-secrets/<name> or /rt-configmaps/<name> unless mountPath is set
+func addSourceVolume(podSpec *v1.Pod, containerSpec *v1.Container, ref sourceRef) {
+	items := []v1.KeyToPath{}
+	for _, item := range ref.items {
+		items = append(items, v1.KeyToPath{Key: item, Path: item})
+	}
+	if len(items) == 0 {
+		items = nil
+	}
+
+	volume := v1.Volume{}
+	dst := ref.mountPath
+	switch ref.kind {
+	case sourceSecret:
+		if dst == "" {
+			dst = "/rt-secrets/" + ref.name
+		}
+		volume.Name = ref.name
+		volume.VolumeSource.Secret = &v1.SecretVolumeSource{
+			SecretName:  ref.name,
+			Items:       items,
+			DefaultMode: ptr(int32(0600)), // since we use fsGroup - it will result in 0640 in reality
+			Optional:    ptr(true),
+		}
+	case sourceConfigMap:
+		if dst == "" {
+			dst = "/rt-configmaps/" + ref.name
+		}
+		// volume name must not clash with the secret volume of the same name
+		volume.Name = "configmap-" + ref.name
+		volume.VolumeSource.ConfigMap = &v1.ConfigMapVolumeSource{
+			LocalObjectReference: v1.LocalObjectReference{Name: ref.name},
+			Items:                items,
+			Optional:             ptr(true),
+		}
+	}
+	log.Info.Printf("Adding %s volume %s -> %s", ref.kind, ref.name, dst)
+	if len(items) > 0 {
+		log.Info.Printf("Volume %s %s: custom items %v", ref.kind, ref.name, items)
+	}
+
+	podSpec.Spec.Volumes = append(podSpec.Spec.Volumes, volume)
+	containerSpec.VolumeMounts = append(containerSpec.VolumeMounts, v1.VolumeMount{
+		Name:      volume.Name,
+		MountPath: dst,
+		ReadOnly:  true,
+	})
+}
@@ -1,34 +1,19 @@
 package cmd
 
 import (
-	"github.com/plumber-cd/runtainer/discover/aws"
-	"github.com/plumber-cd/runtainer/discover/golang"
-	"github.com/plumber-cd/runtainer/discover/helm"
-	"github.com/plumber-cd/runtainer/discover/java"
-	"github.com/plumber-cd/runtainer/discover/kube"
-	"github.com/plumber-cd/runtainer/discover/system"
-	"github.com/plumber-cd/runtainer/discover/tf"
-	"github.com/plumber-cd/runtainer/env"
-	"github.com/plumber-cd/runtainer/host"
+	"context"
+
 	"github.com/plumber-cd/runtainer/image"
-	"github.com/plumber-cd/runtainer/log"
-	"github.com/plumber-cd/runtainer/volumes"
+	"github.com/plumber-cd/runtainer/runtainer"
+	"github.com/plumber-cd/runtainer/spec"
 )
 
-func discover(imageName string) {
-	log.Debug.Print("Start discovery routine")
-
-	host.DiscoverHost()
-	env.DiscoverEnv()
-	env.DiscoverPorts()
-	image.DiscoverImage(imageName)
-	volumes.DiscoverVolumes()
-
-	system.Discover()
-	aws.Discover()
-	kube.Discover()
-	golang.Discover()
-	java.Discover()
-	tf.Discover()
-	helm.Discover()
+// discover runs discovery for the image or exits with the error.
+// discoverImage is either image.DiscoverImage to probe the image or image.AssumeImage to skip it.
+func discover(imageName string, discoverImage func(context.Context, string) (image.Image, error)) *spec.RunSpec {
+	s, err := runtainer.Discover(context.Background(), imageName, discoverImage)
+	if err != nil {
+		exit(err)
+	}
+	return s
 }
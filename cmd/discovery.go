@@ -0,0 +1,64 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	discovery "github.com/plumber-cd/runtainer/discover"
+	"github.com/plumber-cd/runtainer/log"
+)
+
+func init() {
+	rootCmd.AddCommand(discoveryCmd)
+	discoveryCmd.AddCommand(discoveryListCmd)
+}
+
+var discoveryCmd = &cobra.Command{
+	Use:   "discovery",
+	Short: "Inspect discoverers",
+}
+
+var discoveryListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List available discoverers and their features",
+	Long: `Lists every discoverer in the order they run, along with its features.
+Shows if it is enabled, and if not - why, i.e. which --disable-discovery flag or config disabled it.
+Discoverers that only apply to images with their tool on the PATH are marked as such, as the image is not known here.
+Use the NAME (or FEATURE for features) with --disable-discovery to disable it.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		log.Debug.Print("Start discovery list command execution")
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "NAME\tSTATUS\tDESCRIPTION\tREASON")
+
+		row := func(name, description string, enabled bool, reason string) {
+			status := "enabled"
+			if !enabled {
+				status = "disabled"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", name, status, description, reason)
+		}
+
+		for _, d := range discovery.Registered() {
+			description := d.Description
+			if d.Applies != nil {
+				description += " (only some images)"
+			}
+			enabled, reason := discovery.Status(d, d.Name, nil)
+			row(d.Name, description, enabled, reason)
+
+			for _, f := range discovery.FeaturesOf(d) {
+				enabled, reason := discovery.Status(d, f.DisableKey, nil)
+				row("  "+f.DisableKey, f.Description, enabled, reason)
+			}
+		}
+
+		if err := w.Flush(); err != nil {
+			exit(err)
+		}
+	},
+}
@@ -0,0 +1,213 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/plumber-cd/runtainer/config"
+	"github.com/plumber-cd/runtainer/env"
+	"github.com/plumber-cd/runtainer/image"
+	"github.com/plumber-cd/runtainer/log"
+	"github.com/plumber-cd/runtainer/provenance"
+	"github.com/plumber-cd/runtainer/spec"
+)
+
+// settingFlags maps settings to the CLI flags where their names differ
+var settingFlags = map[string]string{
+	"envFiles":            "env-file",
+	"dotEnv":              "dot-env",
+	"secrets.env":         "secret-env",
+	"secrets.volumes":     "secret-volume",
+	"secrets.envKeys":     "secret-env-key",
+	"secrets.files":       "file-secret",
+	"secrets.envFiles":    "env-file-secret",
+	"configmaps.env":      "configmap-env",
+	"configmaps.volumes":  "configmap-volume",
+	"configmaps.envKeys":  "configmap-env-key",
+	"configmaps.files":    "file-configmap",
+	"configmaps.envFiles": "env-file-configmap",
+	"fieldEnv":            "field-env",
+	"discovery.disabled":  "disable-discovery",
+}
+
+// explainPodSettings are the settings affecting the pod spec, in the order they are printed
+var explainPodSettings = []string{
+	"secret",
+	"run-as-current-user",
+	"run-as-current-group",
+	"interactive",
+	"stdin",
+	"tty",
+	"startup-timeout",
+	"timeout",
+	"ephemeral-secrets",
+	"secrets.env",
+	"secrets.volumes",
+	"secrets.envKeys",
+	"secrets.files",
+	"secrets.envFiles",
+	"configmaps.env",
+	"configmaps.volumes",
+	"configmaps.envKeys",
+	"configmaps.files",
+	"configmaps.envFiles",
+	"fieldEnv",
+	"watch",
+	"discovery.disabled",
+}
+
+var explainProbe bool
+
+func init() {
+	provenance.SetSettingResolver(settingOrigin)
+
+	rootCmd.AddCommand(explainCmd)
+
+	explainCmd.Flags().BoolVar(&explainProbe, "probe", false, "Probe the image for its facts (user, home) with a short-lived pod, instead of assuming Linux image running as root")
+	explainCmd.Flags().SetInterspersed(false)
+}
+
+// settingOrigin tells where the setting value came from, in the order of viper precedence
+func settingOrigin(key string) string {
+	flagName := key
+	if f, ok := settingFlags[key]; ok {
+		flagName = f
+	}
+	if f := rootCmd.PersistentFlags().Lookup(flagName); f != nil && f.Changed {
+		return "flag --" + flagName
+	}
+
+	envName := "RT_" + strings.ToUpper(strings.ReplaceAll(key, ".", "_"))
+	if _, ok := os.LookupEnv(envName); ok {
+		return "env " + envName
+	}
+
+	if config.Source(key) != "" {
+		return "config " + config.Describe(key)
+	}
+
+	return "default"
+}
+
+var explainCmd = &cobra.Command{
+	Use:   "explain [runtainer flags] image [container cmd] [-- [container args]]",
+	Short: "Print effective settings along with where they came from",
+	Long: `Runs the discovery and prints every env variable, volume, port and pod setting as a table,
+along with its origin: a discoverer, a config file and key, a CLI flag or an RT_* env variable.
+Does not create a pod, unless --probe is used.
+Without --probe, the image is assumed to be Linux running as root, so paths inside the container might differ from the actual run.`,
+	DisableFlagsInUseLine: true,
+	Args:                  cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		log.Debug.Print("Start explain command execution")
+
+		backendArgs, containerArgs := splitArgs(args)
+		imageName, containerCmd := backendArgs[0], backendArgs[1:]
+
+		imageOrigin := "probe"
+		var s *spec.RunSpec
+		if explainProbe {
+			s = discover(imageName, image.DiscoverImage)
+		} else {
+			imageOrigin = "assumed, use --probe to check"
+			s = discover(imageName, image.AssumeImage)
+		}
+
+		e, p, i, v := s.Env, s.Ports, s.Image, s.Volumes
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "KIND\tNAME\tVALUE\tORIGIN")
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", provenance.KindPod, "image", imageName, "argument")
+		if len(containerCmd) > 0 || len(containerArgs) > 0 {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", provenance.KindPod, "command", strings.Join(append(containerCmd, containerArgs...), " "), "argument")
+		}
+		cwdOrigin := settingOrigin("dir")
+		if cwdOrigin == "default" {
+			cwdOrigin = "runtainer (cwd)"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", provenance.KindPod, "workingDir", v.ContainerCwd, cwdOrigin)
+		for _, key := range explainPodSettings {
+			origin := settingOrigin(key)
+			val := viper.Get(key)
+			if origin == "default" && isEmptySetting(val) {
+				continue
+			}
+			fmt.Fprintf(w, "%s\t%s\t%v\t%s\n", provenance.KindPod, key, log.Redact(fmt.Sprint(val)), origin)
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", "image", "user", fmt.Sprintf("%s (%d:%d)", i.User, i.UID, i.GID), imageOrigin)
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", "image", "home", i.Home, imageOrigin)
+
+		for _, r := range provenance.Records() {
+			var value string
+			switch r.Kind {
+			case provenance.KindEnv:
+				val, ok := e[r.Name]
+				if !ok {
+					continue
+				}
+				switch val := val.(type) {
+				case nil:
+					value = "(from host)"
+				case env.SecretKeyRef:
+					value = val.String()
+				default:
+					value = log.Redact(fmt.Sprint(val))
+				}
+			case provenance.KindVolume:
+				for _, vol := range v.HostMapping {
+					if vol.Dest == r.Name {
+						value = vol.Src
+					}
+				}
+				if value == "" {
+					continue
+				}
+			case provenance.KindPort:
+				for local, remote := range p {
+					if fmt.Sprint(local) == r.Name {
+						value = fmt.Sprintf("%d:%d", local, remote)
+					}
+				}
+				if value == "" {
+					continue
+				}
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", r.Kind, r.Name, value, r.Origin)
+		}
+
+		// env variables published without going through the tracked paths
+		for name := range e {
+			if provenance.Get(provenance.KindEnv, name) == "" {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", provenance.KindEnv, name, "", "unknown")
+			}
+		}
+
+		if err := w.Flush(); err != nil {
+			exit(err)
+		}
+	},
+}
+
+// isEmptySetting tells if the setting has a zero value, so it is not worth printing
+func isEmptySetting(val interface{}) bool {
+	switch val := val.(type) {
+	case nil:
+		return true
+	case string:
+		return val == ""
+	case bool:
+		return !val
+	case []string:
+		return len(val) == 0
+	case []interface{}:
+		return len(val) == 0
+	}
+	return false
+}
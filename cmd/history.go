@@ -0,0 +1,236 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/plumber-cd/runtainer/history"
+	"github.com/plumber-cd/runtainer/log"
+	"github.com/plumber-cd/runtainer/runtainer"
+	"github.com/plumber-cd/runtainer/utils"
+)
+
+var (
+	historyImage  string
+	historyFailed bool
+	historyCwd    bool
+	historyLimit  int
+)
+
+func init() {
+	rootCmd.AddCommand(historyCmd)
+	historyCmd.AddCommand(historyShowCmd)
+	rootCmd.AddCommand(rerunCmd)
+
+	historyCmd.Flags().StringVar(&historyImage, "image", "", "Only show runs with image name containing this string")
+	historyCmd.Flags().BoolVar(&historyFailed, "failed", false, "Only show failed runs")
+	historyCmd.Flags().BoolVar(&historyCwd, "cwd", false, "Only show runs from the current working directory")
+	historyCmd.Flags().IntVarP(&historyLimit, "limit", "n", 20, "Show only this many latest runs, 0 to show all")
+}
+
+// newHistoryEntry captures everything about the run that is known before it started
+func newHistoryEntry(imageName string, containerCmd, containerArgs []string) *history.Entry {
+	settings := map[string]interface{}{}
+	settingsJson, err := json.Marshal(viper.AllSettings())
+	if err != nil {
+		exit(err)
+	}
+	if err := json.Unmarshal(settingsJson, &settings); err != nil {
+		exit(err)
+	}
+
+	argv := make([]string, 0, len(os.Args))
+	for _, arg := range os.Args {
+		argv = append(argv, log.Mask(arg))
+	}
+
+	return &history.Entry{
+		ID:       utils.RandomHex(4),
+		Time:     time.Now(),
+		Argv:     argv,
+		Image:    imageName,
+		Command:  containerCmd,
+		Args:     containerArgs,
+		Settings: scrubSettings(settings),
+	}
+}
+
+// scrubSettings removes secrets from the settings before they are recorded.
+// Values of sensitive env variables are not recorded at all, so on rerun they are mirrored from the host env instead.
+// Anything else that looks like a secret is masked.
+func scrubSettings(settings map[string]interface{}) map[string]interface{} {
+	if vars, ok := settings["env"].([]interface{}); ok {
+		for i, v := range vars {
+			kv, ok := v.(string)
+			if !ok {
+				continue
+			}
+			split := strings.SplitN(kv, "=", 2)
+			if len(split) == 2 && (log.IsSensitive(split[0]) || log.Mask(split[1]) != split[1]) {
+				vars[i] = split[0]
+			}
+		}
+	}
+
+	if vars, ok := settings["environment"].(map[string]interface{}); ok {
+		for name, val := range vars {
+			// see env.ResolveValue, references to files, commands and secrets are not secrets themselves
+			if m, structured := val.(map[string]interface{}); structured && m["default"] == nil {
+				continue
+			}
+			if log.IsSensitive(name) {
+				vars[name] = nil
+			} else if str, ok := val.(string); ok && log.Mask(str) != str {
+				vars[name] = nil
+			}
+		}
+	}
+
+	return maskSettings(settings).(map[string]interface{})
+}
+
+// maskSettings masks known secrets in every string value, recursively
+func maskSettings(val interface{}) interface{} {
+	switch v := val.(type) {
+	case string:
+		return log.Mask(v)
+	case []interface{}:
+		for i := range v {
+			v[i] = maskSettings(v[i])
+		}
+	case map[string]interface{}:
+		for key := range v {
+			v[key] = maskSettings(v[key])
+		}
+	}
+	return val
+}
+
+// recordHistory completes the entry with the run results and records it to the history file, unless disabled
+func recordHistory(entry *history.Entry, result runtainer.Result, runErr error) {
+	if !viper.GetBool("history") {
+		log.Debug.Print("--history disabled, skip recording")
+		return
+	}
+
+	entry.Duration = time.Since(entry.Time)
+	entry.Cwd = result.Spec.Host.Cwd
+	entry.ExitCode = result.ExitCode
+	if runErr != nil {
+		entry.ExitCode = -1
+	}
+	entry.Pod = result.PodName
+	entry.ImageID = result.ImageID
+
+	if err := history.Append(*entry); err != nil {
+		log.Normal.Printf("Failed to record history: %s", err)
+	}
+}
+
+var historyCmd = &cobra.Command{
+	Use:   "history",
+	Short: "List previous runs",
+	Long: `Every run is recorded to ~/.runtainer/history.jsonl, unless --history=false.
+Use runtainer history show <id> to see full details and runtainer rerun <id> to replay it.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		entries, err := history.Load()
+		if err != nil {
+			exit(err)
+		}
+
+		cwd, err := os.Getwd()
+		if err != nil {
+			exit(err)
+		}
+
+		filtered := []history.Entry{}
+		for _, e := range entries {
+			if historyImage != "" && !strings.Contains(e.Image, historyImage) {
+				continue
+			}
+			if historyFailed && e.ExitCode == 0 {
+				continue
+			}
+			if historyCwd && e.Cwd != cwd {
+				continue
+			}
+			filtered = append(filtered, e)
+		}
+		if historyLimit > 0 && len(filtered) > historyLimit {
+			filtered = filtered[len(filtered)-historyLimit:]
+		}
+
+		table := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(table, "ID\tTIME\tIMAGE\tEXIT CODE\tDURATION\tCWD\tCOMMAND")
+		for _, e := range filtered {
+			fmt.Fprintf(
+				table,
+				"%s\t%s\t%s\t%d\t%s\t%s\t%s\n",
+				e.ID,
+				e.Time.Local().Format("2006-01-02 15:04:05"),
+				e.Image,
+				e.ExitCode,
+				e.Duration.Round(time.Millisecond),
+				e.Cwd,
+				strings.Join(append(append([]string{}, e.Command...), e.Args...), " "),
+			)
+		}
+		if err := table.Flush(); err != nil {
+			exit(err)
+		}
+	},
+}
+
+var historyShowCmd = &cobra.Command{
+	Use:   "show id",
+	Short: "Show full details of a previous run",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		entry, err := history.Find(args[0])
+		if err != nil {
+			exit(err)
+		}
+
+		out, err := json.MarshalIndent(entry, "", "  ")
+		if err != nil {
+			exit(err)
+		}
+		fmt.Println(string(out))
+	},
+}
+
+var rerunCmd = &cobra.Command{
+	Use:   "rerun id",
+	Short: "Replay a previous run",
+	Long: `Runs the same command in the same cwd with the same settings as the previous run.
+If the cluster reported a resolved image digest, the exact same image will be used.
+Host, image, volumes and ports facts are discovered again.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		log.Debug.Print("Start rerun command execution")
+
+		entry, err := history.Find(args[0])
+		if err != nil {
+			exit(err)
+		}
+
+		for key, val := range entry.Settings {
+			log.Debug.Printf("Replaying setting %s", key)
+			viper.Set(key, val)
+		}
+		viper.Set("dir", entry.Cwd)
+
+		imageName := entry.Reference()
+		log.Normal.Printf("Re-running %s: %s", entry.ID, imageName)
+
+		run(imageName, entry.Command, entry.Args)
+	},
+}
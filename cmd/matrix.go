@@ -0,0 +1,171 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/plumber-cd/runtainer/backends/k8s"
+	"github.com/plumber-cd/runtainer/failure"
+	"github.com/plumber-cd/runtainer/history"
+	"github.com/plumber-cd/runtainer/host"
+	"github.com/plumber-cd/runtainer/image"
+	"github.com/plumber-cd/runtainer/log"
+	"github.com/plumber-cd/runtainer/runtainer"
+	"github.com/plumber-cd/runtainer/spec"
+	"github.com/plumber-cd/runtainer/utils"
+)
+
+var matrixImages []string
+
+func init() {
+	rootCmd.AddCommand(matrixCmd)
+
+	matrixCmd.Flags().StringSliceVar(&matrixImages, "image", []string{}, "Image to run the command in, can be repeated, i.e. --image golang:1.20 --image golang:1.21")
+	matrixCmd.Flags().SetInterspersed(false)
+}
+
+// matrixRun is a result of the command execution in one of the images
+type matrixRun struct {
+	image    string
+	spec     *spec.RunSpec
+	options  *host.PodOptions
+	history  *history.Entry
+	stdout   *utils.PrefixWriter
+	stderr   *utils.PrefixWriter
+	result   runtainer.Result
+	err      error
+	duration time.Duration
+}
+
+var matrixCmd = &cobra.Command{
+	Use:   "matrix --image image [--image image...] [runtainer flags] [container cmd] [-- [container args]]",
+	Short: "Run the same command in multiple images in parallel",
+	Long: `Runs the same command in one pod per image concurrently.
+Output lines are prefixed with the image name.
+Once all pods are finished, a summary table is printed to StdErr.
+Exits with non-zero code if any of the runs failed.
+Matrix runs are never interactive: --interactive, --stdin and --tty are ignored, as well as --port, --watch and --record.`,
+	DisableFlagsInUseLine: true,
+	Run: func(cmd *cobra.Command, args []string) {
+		log.Debug.Print("Start matrix command execution")
+
+		if len(matrixImages) == 0 {
+			exit(failure.Errorf(failure.Config, "At least one --image is required"))
+		}
+
+		containerCmd, containerArgs := splitArgs(args)
+
+		// there is only one terminal, it can't be shared across multiple pods
+		viper.Set("interactive", false)
+		viper.Set("stdin", false)
+		viper.Set("tty", false)
+		viper.Set("watch", []string{})
+		viper.Set("record", "")
+
+		mutex := &sync.Mutex{}
+		runs := make([]*matrixRun, 0, len(matrixImages))
+		for _, imageName := range matrixImages {
+			log.Debug.Printf("Image: %s", imageName)
+
+			// provenance is global, so discovery can't run concurrently
+			s := discover(imageName, image.DiscoverImage)
+
+			prefix := fmt.Sprintf("[%s] ", imageName)
+			stdout := utils.NewPrefixWriter(os.Stdout, mutex, prefix)
+			stderr := utils.NewPrefixWriter(os.Stderr, mutex, prefix)
+			podOptions, podSpecJson, err := k8s.NewPodOptions(s, k8s.Streams{Stdout: stdout, Stderr: stderr}, containerCmd, containerArgs)
+			if err != nil {
+				exit(err)
+			}
+			if viper.GetBool("dry-run") {
+				log.Debug.Print("--dry-run mode enabled")
+				fmt.Println("---")
+				fmt.Println(log.Redact(podSpecJson))
+				continue
+			}
+
+			// pods are started in parallel, there is no single line to draw their status on
+			podOptions.ProgressOut = nil
+
+			if len(podOptions.Ports) > 0 {
+				log.Normal.Printf("Port forwarding is not supported in matrix mode, ignoring for %s", imageName)
+				podOptions.Ports = nil
+			}
+
+			run := &matrixRun{
+				image:   imageName,
+				spec:    s,
+				options: podOptions,
+				history: newHistoryEntry(imageName, containerCmd, containerArgs),
+				stdout:  stdout,
+				stderr:  stderr,
+			}
+
+			runs = append(runs, run)
+		}
+
+		log.SetPhase("run")
+		wg := sync.WaitGroup{}
+		for _, run := range runs {
+			wg.Add(1)
+			go func(run *matrixRun) {
+				defer wg.Done()
+
+				start := time.Now()
+				run.result, run.err = runtainer.NewResult(run.spec, run.options, host.ExecPod(run.options))
+				run.duration = time.Since(start)
+				recordHistory(run.history, run.result, run.err)
+
+				for _, w := range []*utils.PrefixWriter{run.stdout, run.stderr} {
+					if err := w.Flush(); err != nil {
+						log.Error.Print(err)
+					}
+				}
+			}(run)
+		}
+		wg.Wait()
+
+		if len(runs) == 0 {
+			return
+		}
+
+		failed := false
+		summary := new(bytes.Buffer)
+		table := tabwriter.NewWriter(summary, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(table, "IMAGE\tPOD\tSTATUS\tEXIT CODE\tDURATION")
+		for _, run := range runs {
+			status := "OK"
+			if run.err != nil || run.result.ExitCode != 0 {
+				failed = true
+				status = "FAILED"
+			}
+			if run.err != nil {
+				log.Error.Printf("%s: %s", run.image, run.err)
+			}
+			fmt.Fprintf(
+				table,
+				"%s\t%s\t%s\t%d\t%s\n",
+				run.image,
+				run.options.PodSpec.ObjectMeta.Name,
+				status,
+				run.result.ExitCode,
+				run.duration.Round(time.Millisecond),
+			)
+		}
+		if err := table.Flush(); err != nil {
+			exit(err)
+		}
+		log.Normal.Printf("Matrix summary:\n%s", summary.String())
+
+		if failed {
+			os.Exit(1)
+		}
+	},
+}
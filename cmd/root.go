@@ -1,15 +1,17 @@
 package cmd
 
 import (
-	"encoding/json"
+	"context"
 	llog "log"
 	"os"
 	"path/filepath"
 	"strings"
 
 	homedir "github.com/mitchellh/go-homedir"
-	"github.com/plumber-cd/runtainer/backends/k8s"
+	"github.com/plumber-cd/runtainer/config"
+	"github.com/plumber-cd/runtainer/failure"
 	"github.com/plumber-cd/runtainer/log"
+	"github.com/plumber-cd/runtainer/runtainer"
 	"github.com/plumber-cd/runtainer/utils"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -37,21 +39,37 @@ var (
 			// On the left, args considered to be passed to the backend (docker/kubectl/etc), on the right args considered to be passed to the container
 			containerCmd, containerArgs := splitArgs(args[1:])
 
-			// run discovery routines that will publish all the facts to viper for backend engine to interpret
-			discover(imageName)
-
-			// just for debugging, dump full viper data before passing it to the backends
-			allSettings, err := json.MarshalIndent(viper.AllSettings(), "", "  ")
-			if err != nil {
-				log.Normal.Panic(err)
-			}
-			log.Debug.Printf("Settings: %s", string(allSettings))
-
-			k8s.Run(containerCmd, containerArgs)
+			run(imageName, containerCmd, containerArgs)
 		},
 	}
 )
 
+// run discovers everything and runs the container, then exits with the container exit code
+func run(imageName string, containerCmd, containerArgs []string) {
+	entry := newHistoryEntry(imageName, containerCmd, containerArgs)
+	result, err := runtainer.Run(context.Background(), runtainer.Options{
+		Image:   imageName,
+		Command: containerCmd,
+		Args:    containerArgs,
+	})
+	if result.Spec != nil && !viper.GetBool("dry-run") {
+		recordHistory(entry, result, err)
+	}
+
+	if err != nil {
+		exit(err)
+	}
+	if result.ExitCode != 0 {
+		os.Exit(result.ExitCode)
+	}
+}
+
+// exit reports the error in one line and exits with the code of its kind, see failure.Kind.ExitCode
+func exit(err error) {
+	log.Normal.Print(failure.Message(err))
+	os.Exit(failure.ExitCode(err))
+}
+
 // Execute executes the root command.
 func Execute() error {
 	return rootCmd.Execute()
@@ -82,7 +100,38 @@ func init() {
 		llog.Panic(err)
 	}
 
-	rootCmd.PersistentFlags().BoolP("interactive", "i", true, `Disable to not to attach to the container.
+	rootCmd.PersistentFlags().String("log-file", "", "Log file path (default is $HOME/.runtainer/logs/<date>.log)")
+	if err := viper.BindPFlag("log-file", rootCmd.PersistentFlags().Lookup("log-file")); err != nil {
+		llog.Panic(err)
+	}
+
+	rootCmd.PersistentFlags().String("log-format", config.DefaultLogFormat, "Log file format, text or json")
+	if err := viper.BindPFlag("log-format", rootCmd.PersistentFlags().Lookup("log-format")); err != nil {
+		llog.Panic(err)
+	}
+
+	rootCmd.PersistentFlags().Int("log-max-size", config.DefaultLogMaxSize, "Max size of the log file in megabytes before it gets rotated")
+	if err := viper.BindPFlag("log-max-size", rootCmd.PersistentFlags().Lookup("log-max-size")); err != nil {
+		llog.Panic(err)
+	}
+
+	rootCmd.PersistentFlags().Int("log-max-age", config.DefaultLogMaxAge, "Max age of the log files in days before they get removed")
+	if err := viper.BindPFlag("log-max-age", rootCmd.PersistentFlags().Lookup("log-max-age")); err != nil {
+		llog.Panic(err)
+	}
+
+	rootCmd.PersistentFlags().StringSlice("redact", []string{}, `Additional env variable name patterns which values must be redacted from logs and --dry-run output, i.e. --redact 'MY_*'.
+	Built-in patterns are *_SECRET*, *SECRET_*, *TOKEN*, *PASSWORD*, *PASSWD* and AWS keys.`)
+	if err := viper.BindPFlag("redact", rootCmd.PersistentFlags().Lookup("redact")); err != nil {
+		llog.Panic(err)
+	}
+
+	rootCmd.PersistentFlags().Bool("show-secrets", false, "Disable redaction of the secrets from logs and --dry-run output")
+	if err := viper.BindPFlag("show-secrets", rootCmd.PersistentFlags().Lookup("show-secrets")); err != nil {
+		llog.Panic(err)
+	}
+
+	rootCmd.PersistentFlags().BoolP("interactive", "i", config.DefaultInteractive, `Disable to not to attach to the container.
 	By default we wait till pod becomes Running and then - attaching to it.
 	If container expected to run a script in non-interactive mode and exit,
 	- the tool might try to attach to the container that is already finished and fail.
@@ -93,12 +142,12 @@ func init() {
 		llog.Panic(err)
 	}
 
-	rootCmd.PersistentFlags().BoolP("stdin", "s", true, "Redirect host StdIn to the container")
+	rootCmd.PersistentFlags().BoolP("stdin", "s", config.DefaultStdin, "Redirect host StdIn to the container")
 	if err := viper.BindPFlag("stdin", rootCmd.PersistentFlags().Lookup("stdin")); err != nil {
 		llog.Panic(err)
 	}
 
-	rootCmd.PersistentFlags().BoolP("tty", "t", true, "Enable TTY, disable if piping something to stdin")
+	rootCmd.PersistentFlags().BoolP("tty", "t", config.DefaultTty, "Enable TTY, disable if piping something to stdin")
 	if err := viper.BindPFlag("tty", rootCmd.PersistentFlags().Lookup("tty")); err != nil {
 		llog.Panic(err)
 	}
@@ -113,6 +162,16 @@ func init() {
 		llog.Panic(err)
 	}
 
+	rootCmd.PersistentFlags().StringSlice("env-file", []string{}, "Load env variables from the dotenv file, applied before --env, i.e. --env-file .env.local")
+	if err := viper.BindPFlag("envFiles", rootCmd.PersistentFlags().Lookup("env-file")); err != nil {
+		llog.Panic(err)
+	}
+
+	rootCmd.PersistentFlags().Bool("dot-env", false, "Load .env file from the --dir directory (the current directory by default) if it exists, before any --env-file")
+	if err := viper.BindPFlag("dotEnv", rootCmd.PersistentFlags().Lookup("dot-env")); err != nil {
+		llog.Panic(err)
+	}
+
 	rootCmd.PersistentFlags().StringSlice("secret-env", []string{}, "Mapping for env secrets, i.e. --secret-env foo secret-env bar")
 	if err := viper.BindPFlag("secrets.env", rootCmd.PersistentFlags().Lookup("secret-env")); err != nil {
 		llog.Panic(err)
@@ -133,6 +192,51 @@ func init() {
 		llog.Panic(err)
 	}
 
+	rootCmd.PersistentFlags().StringSlice("secret-env-key", []string{}, "Env variable from a single secret key, i.e. --secret-env-key MY_VAR=secret/key --secret-env-key OTHER=secret/other:optional")
+	if err := viper.BindPFlag("secrets.envKeys", rootCmd.PersistentFlags().Lookup("secret-env-key")); err != nil {
+		llog.Panic(err)
+	}
+
+	rootCmd.PersistentFlags().StringSlice("configmap-env-key", []string{}, "Env variable from a single config map key, i.e. --configmap-env-key MY_VAR=configmap/key:optional")
+	if err := viper.BindPFlag("configmaps.envKeys", rootCmd.PersistentFlags().Lookup("configmap-env-key")); err != nil {
+		llog.Panic(err)
+	}
+
+	rootCmd.PersistentFlags().StringSlice("field-env", []string{}, "Env variable from the downward API field, i.e. --field-env POD_NAME=podName --field-env NODE=spec.nodeName (shortcuts: podName, namespace, nodeName, podIP, hostIP)")
+	if err := viper.BindPFlag("fieldEnv", rootCmd.PersistentFlags().Lookup("field-env")); err != nil {
+		llog.Panic(err)
+	}
+
+	rootCmd.PersistentFlags().StringSlice("configmap-env", []string{}, "Mapping for env config maps, i.e. --configmap-env foo --configmap-env bar:prefix=BAR_")
+	if err := viper.BindPFlag("configmaps.env", rootCmd.PersistentFlags().Lookup("configmap-env")); err != nil {
+		llog.Panic(err)
+	}
+
+	rootCmd.PersistentFlags().StringSlice("configmap-volume", []string{}, "Mapping for config map volumes, i.e. --configmap-volume foo --configmap-volume bar:mountPath=/bar:item=settings.xml")
+	if err := viper.BindPFlag("configmaps.volumes", rootCmd.PersistentFlags().Lookup("configmap-volume")); err != nil {
+		llog.Panic(err)
+	}
+
+	rootCmd.PersistentFlags().StringSlice("file-secret", []string{}, "Upload host file into ephemeral secret and mount it, i.e. --file-secret ./creds.json:/etc/creds.json")
+	if err := viper.BindPFlag("secrets.files", rootCmd.PersistentFlags().Lookup("file-secret")); err != nil {
+		llog.Panic(err)
+	}
+
+	rootCmd.PersistentFlags().StringSlice("env-file-secret", []string{}, "Upload host dotenv file into ephemeral secret and use it as envFrom, i.e. --env-file-secret .env.local")
+	if err := viper.BindPFlag("secrets.envFiles", rootCmd.PersistentFlags().Lookup("env-file-secret")); err != nil {
+		llog.Panic(err)
+	}
+
+	rootCmd.PersistentFlags().StringSlice("file-configmap", []string{}, "Upload non-sensitive host file into ephemeral config map and mount it, i.e. --file-configmap ./settings.xml:/etc/settings.xml")
+	if err := viper.BindPFlag("configmaps.files", rootCmd.PersistentFlags().Lookup("file-configmap")); err != nil {
+		llog.Panic(err)
+	}
+
+	rootCmd.PersistentFlags().StringSlice("env-file-configmap", []string{}, "Upload non-sensitive host dotenv file into ephemeral config map and use it as envFrom, i.e. --env-file-configmap .env")
+	if err := viper.BindPFlag("configmaps.envFiles", rootCmd.PersistentFlags().Lookup("env-file-configmap")); err != nil {
+		llog.Panic(err)
+	}
+
 	rootCmd.PersistentFlags().StringP("secret", "S", "", "Optionally, provide a name of the secret to be used for the image pull")
 	if err := viper.BindPFlag("secret", rootCmd.PersistentFlags().Lookup("secret")); err != nil {
 		llog.Panic(err)
@@ -143,21 +247,67 @@ func init() {
 		llog.Panic(err)
 	}
 
-	rootCmd.PersistentFlags().BoolP("run-as-current-user", "U", true, "Will set runAsUser to the current host UID.")
+	rootCmd.PersistentFlags().BoolP("run-as-current-user", "U", config.DefaultRunAsCurrentUser, "Will set runAsUser to the current host UID.")
 	if err := viper.BindPFlag("run-as-current-user", rootCmd.PersistentFlags().Lookup("run-as-current-user")); err != nil {
 		llog.Panic(err)
 	}
 
-	rootCmd.PersistentFlags().BoolP("run-as-current-group", "G", true, "Will set runAsGroup to the current host GID. Ignored if -U=false. If disabled - will set fsGroup to the current host GID instead.")
+	rootCmd.PersistentFlags().BoolP("run-as-current-group", "G", config.DefaultRunAsCurrentGroup, "Will set runAsGroup to the current host GID. Ignored if -U=false. If disabled - will set fsGroup to the current host GID instead.")
 	if err := viper.BindPFlag("run-as-current-group", rootCmd.PersistentFlags().Lookup("run-as-current-group")); err != nil {
 		llog.Panic(err)
 	}
 
+	rootCmd.PersistentFlags().Bool("ephemeral-secrets", false, `Pass values of sensitive env variables via short-lived secret owned by the pod instead of inline pod env.
+	Sensitive are the variables marked as such by discovery (i.e. AWS credentials) and anything matching --redact patterns.`)
+	if err := viper.BindPFlag("ephemeral-secrets", rootCmd.PersistentFlags().Lookup("ephemeral-secrets")); err != nil {
+		llog.Panic(err)
+	}
+
+	rootCmd.PersistentFlags().Duration("startup-timeout", config.DefaultStartupTimeout, `How long to wait for the pod to be scheduled, pull the image and start, 0 to wait forever.
+	Pods that will never start on their own, i.e. with ImagePullBackOff, fail right away regardless.`)
+	if err := viper.BindPFlag("startup-timeout", rootCmd.PersistentFlags().Lookup("startup-timeout")); err != nil {
+		llog.Panic(err)
+	}
+
+	rootCmd.PersistentFlags().Duration("timeout", 0, `Limit the total runtime of the pod, i.e. --timeout 30m, 0 for no limit.
+	The pod is deleted on timeout, and it is also set as activeDeadlineSeconds in case runtainer won't be around to delete it.`)
+	if err := viper.BindPFlag("timeout", rootCmd.PersistentFlags().Lookup("timeout")); err != nil {
+		llog.Panic(err)
+	}
+
+	rootCmd.PersistentFlags().Duration("image-probe-timeout", config.DefaultImageProbeTimeout, "Limit the total runtime of the image probe pod, including the image pull, 0 for no limit")
+	if err := viper.BindPFlag("image-probe-timeout", rootCmd.PersistentFlags().Lookup("image-probe-timeout")); err != nil {
+		llog.Panic(err)
+	}
+
 	rootCmd.PersistentFlags().Bool("dry-run", false, "Dry Run mode will not execute the container, only print to StdOut a pod spec it would have run.")
 	if err := viper.BindPFlag("dry-run", rootCmd.PersistentFlags().Lookup("dry-run")); err != nil {
 		llog.Panic(err)
 	}
 
+	rootCmd.PersistentFlags().String("record", "", "Record the session to the asciicast v2 file, i.e. --record session.cast")
+	if err := viper.BindPFlag("record", rootCmd.PersistentFlags().Lookup("record")); err != nil {
+		llog.Panic(err)
+	}
+
+	rootCmd.PersistentFlags().Bool("record-input", false, "With --record, also record StdIn. Be careful, that will include everything typed, including passwords.")
+	if err := viper.BindPFlag("record-input", rootCmd.PersistentFlags().Lookup("record-input")); err != nil {
+		llog.Panic(err)
+	}
+
+	rootCmd.PersistentFlags().Bool("history", config.DefaultHistory, "Record this run to the history, see runtainer history --help")
+	if err := viper.BindPFlag("history", rootCmd.PersistentFlags().Lookup("history")); err != nil {
+		llog.Panic(err)
+	}
+
+	rootCmd.PersistentFlags().StringSlice("watch", []string{}, `Watch the host cwd and re-run container cmd on changes, i.e. --watch or --watch='*.go'.
+	Pod is kept alive between runs, in-flight run is cancelled on change.
+	Respects .gitignore. Disables --stdin and --tty.`)
+	rootCmd.PersistentFlags().Lookup("watch").NoOptDefVal = "**"
+	if err := viper.BindPFlag("watch", rootCmd.PersistentFlags().Lookup("watch")); err != nil {
+		llog.Panic(err)
+	}
+
 	rootCmd.Flags().SetInterspersed(false)
 }
 
@@ -169,10 +319,10 @@ func initConfig() {
 
 		exists, err := utils.FileExists(cfgFile)
 		if err != nil {
-			log.Normal.Panic(err)
+			exit(failure.Wrap(failure.Config, err))
 		}
 		if !exists {
-			log.Normal.Fatalf("Global config file not found: %s", cfgFile)
+			exit(failure.Errorf(failure.Config, "Global config file not found: %s", cfgFile))
 		}
 
 		// Use config file from the flag.
@@ -183,7 +333,7 @@ func initConfig() {
 		// Find home directory.
 		home, err := homedir.Dir()
 		if err != nil {
-			log.Error.Panic(err)
+			exit(failure.Wrap(failure.Discovery, err))
 		}
 
 		// Search config in home directory with name ".runtainer" (without extension).
@@ -203,16 +353,17 @@ func initConfig() {
 		case viper.ConfigFileNotFoundError:
 			log.Debug.Printf("Global %s, skipping...", err)
 		default:
-			log.Error.Panic(err)
+			exit(failure.Wrap(failure.Config, err))
 		}
 	} else {
 		log.Debug.Print("Using global config file:", viper.ConfigFileUsed())
+		trackConfig(viper.ConfigFileUsed())
 	}
 
 	// try to read (if exists) local config file in the cwd
 	cwd, err := os.Getwd()
 	if err != nil {
-		log.Error.Panic(err)
+		exit(failure.Wrap(failure.Discovery, err))
 	}
 	readLocalConfig(cwd)
 
@@ -242,16 +393,28 @@ func readLocalConfig(d string) {
 		case viper.ConfigFileNotFoundError:
 			log.Debug.Printf("Local %s, skipping...", err)
 		default:
-			log.Error.Panic(err)
+			exit(failure.Wrap(failure.Config, err))
 		}
 	} else {
 		log.Debug.Print("Using local config file:", v.ConfigFileUsed())
+		config.Track(v.ConfigFileUsed(), v.AllSettings())
 		if err := viper.MergeConfigMap(v.AllSettings()); err != nil {
-			log.Error.Panic(err)
+			exit(failure.Errorf(failure.Config, "%s: %w", v.ConfigFileUsed(), err))
 		}
 	}
 }
 
+// trackConfig records settings from the global config file.
+// The global viper instance also has flags and env variables in it, so the file is read again in isolation.
+func trackConfig(file string) {
+	v := viper.New()
+	v.SetConfigFile(file)
+	if err := v.ReadInConfig(); err != nil {
+		exit(failure.Wrap(failure.Config, err))
+	}
+	config.Track(file, v.AllSettings())
+}
+
 // splitArgs as per that POSIX standard, find the -- delimiter and split args by it
 func splitArgs(args []string) ([]string, []string) {
 	log.Debug.Printf("args: %s", strings.Join(args, " "))
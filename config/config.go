@@ -0,0 +1,47 @@
+// Package config keeps track of where the settings were loaded from,
+// so that the errors can point the user to the right file.
+package config
+
+import (
+	"strings"
+	"sync"
+)
+
+var (
+	sourcesMutex sync.RWMutex
+	// sources maps every leaf setting key (lower case, dot separated) to the file that defined it last
+	sources = map[string]string{}
+)
+
+// Track records the file as the source of all the settings in it.
+// Files must be tracked in the same order they are merged, so that the latter wins.
+func Track(file string, settings map[string]interface{}) {
+	sourcesMutex.Lock()
+	defer sourcesMutex.Unlock()
+	track(file, "", settings)
+}
+
+func track(file, prefix string, settings map[string]interface{}) {
+	for key, val := range settings {
+		key = prefix + strings.ToLower(key)
+		sources[key] = file
+		if m, ok := val.(map[string]interface{}); ok {
+			track(file, key+".", m)
+		}
+	}
+}
+
+// Source returns the file that defined the setting key, or empty string if it didn't come from any file
+func Source(key string) string {
+	sourcesMutex.RLock()
+	defer sourcesMutex.RUnlock()
+	return sources[strings.ToLower(key)]
+}
+
+// Describe returns human readable location of the setting key for the error messages
+func Describe(key string) string {
+	if file := Source(key); file != "" {
+		return file + ": " + key
+	}
+	return key
+}
@@ -0,0 +1,38 @@
+package config
+
+import (
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// Defaults of the settings that are not zero values.
+// CLI flags use them as their defaults, and SetDefaults registers them for those who use runtainer as a library.
+const (
+	DefaultLogFormat         = "text"
+	DefaultLogMaxSize        = 10
+	DefaultLogMaxAge         = 7
+	DefaultInteractive       = true
+	DefaultStdin             = true
+	DefaultTty               = true
+	DefaultRunAsCurrentUser  = true
+	DefaultRunAsCurrentGroup = true
+	DefaultStartupTimeout    = 10 * time.Minute
+	DefaultImageProbeTimeout = 15 * time.Minute
+	DefaultHistory           = true
+)
+
+// SetDefaults registers the defaults with viper, so that they apply even when the CLI flags are not bound
+func SetDefaults() {
+	viper.SetDefault("log-format", DefaultLogFormat)
+	viper.SetDefault("log-max-size", DefaultLogMaxSize)
+	viper.SetDefault("log-max-age", DefaultLogMaxAge)
+	viper.SetDefault("interactive", DefaultInteractive)
+	viper.SetDefault("stdin", DefaultStdin)
+	viper.SetDefault("tty", DefaultTty)
+	viper.SetDefault("run-as-current-user", DefaultRunAsCurrentUser)
+	viper.SetDefault("run-as-current-group", DefaultRunAsCurrentGroup)
+	viper.SetDefault("startup-timeout", DefaultStartupTimeout)
+	viper.SetDefault("image-probe-timeout", DefaultImageProbeTimeout)
+	viper.SetDefault("history", DefaultHistory)
+}
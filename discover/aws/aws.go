@@ -1,42 +1,40 @@
 package aws
 
 import (
-	"golang.org/x/exp/slices"
-
 	"github.com/plumber-cd/runtainer/discover"
 	"github.com/plumber-cd/runtainer/env"
 	"github.com/plumber-cd/runtainer/log"
 	"github.com/plumber-cd/runtainer/volumes"
-	"github.com/spf13/viper"
 )
 
-// Discover specific to AWS
-func Discover() {
-	disabled := viper.GetStringSlice("discovery.disabled")
-	if slices.Contains(disabled, "all") || slices.Contains(disabled, "aws") {
-		return
-	}
+func init() {
+	discover.Register(discover.Discoverer{
+		Name:        "aws",
+		Description: "AWS credentials, profile and region env variables, ~/.aws",
+		Order:       20,
+		Discover:    Discover,
+	})
+}
 
+// Discover specific to AWS
+func Discover(f *discover.Facts) error {
 	log.Debug.Print("Discover AWS")
 
-	// get what's already calculated by now
-	h, e, _, i, v := discover.GetFromViper()
-
-	e.AddEnv(h, &env.DiscoverVariable{Name: "AWS_ACCESS_KEY_ID"})
-	e.AddEnv(h, &env.DiscoverVariable{Name: "AWS_SECRET_ACCESS_KEY"})
-	e.AddEnv(h, &env.DiscoverVariable{Name: "AWS_SESSION_TOKEN"})
-	e.AddEnv(h, &env.DiscoverVariable{Name: "AWS_PROFILE"})
-	e.AddEnv(h, &env.DiscoverVariable{Name: "AWS_ROLE_SESSION_NAME"})
-	e.AddEnv(h, &env.DiscoverVariable{Name: "AWS_DEFAULT_REGION"})
-	e.AddEnv(h, &env.DiscoverVariable{Name: "AWS_STS_REGIONAL_ENDPOINTS"})
-	e.AddEnv(h, &env.DiscoverVariable{Name: "AWS_SDK_LOAD_CONFIG"})
+	f.AddEnv(&env.DiscoverVariable{Config: env.DiscoveryConfig{Sensitive: true}, Name: "AWS_ACCESS_KEY_ID"})
+	f.AddEnv(&env.DiscoverVariable{Config: env.DiscoveryConfig{Sensitive: true}, Name: "AWS_SECRET_ACCESS_KEY"})
+	f.AddEnv(&env.DiscoverVariable{Config: env.DiscoveryConfig{Sensitive: true}, Name: "AWS_SESSION_TOKEN"})
+	f.AddEnv(&env.DiscoverVariable{Name: "AWS_PROFILE"})
+	f.AddEnv(&env.DiscoverVariable{Name: "AWS_ROLE_SESSION_NAME"})
+	f.AddEnv(&env.DiscoverVariable{Name: "AWS_DEFAULT_REGION"})
+	f.AddEnv(&env.DiscoverVariable{Name: "AWS_STS_REGIONAL_ENDPOINTS"})
+	f.AddEnv(&env.DiscoverVariable{Name: "AWS_SDK_LOAD_CONFIG"})
 
-	v.AddHostMount(h, i, "~/.aws",
+	if err := f.AddHostMount("~/.aws",
 		&volumes.DiscoverEnvVar{Config: volumes.DiscoveryConfig{UseParent: true}, EnvVar: "AWS_SHARED_CREDENTIALS_FILE"},
 		&volumes.DiscoverMirror{},
-	)
+	); err != nil {
+		return err
+	}
 
-	log.Debug.Print("Publish to viper")
-	viper.Set("env", e)
-	viper.Set("volumes", v)
+	return nil
 }
@@ -0,0 +1,202 @@
+package custom
+
+import (
+	"github.com/mitchellh/mapstructure"
+
+	"github.com/plumber-cd/runtainer/config"
+	"github.com/plumber-cd/runtainer/discover"
+	"github.com/plumber-cd/runtainer/env"
+	"github.com/plumber-cd/runtainer/failure"
+	"github.com/plumber-cd/runtainer/log"
+	"github.com/plumber-cd/runtainer/templates"
+	"github.com/plumber-cd/runtainer/volumes"
+	"github.com/spf13/viper"
+)
+
+// VolumeSource is one of the ways to find the volume source on the host, exactly one field must be set
+type VolumeSource struct {
+	Env    string
+	Exec   []string
+	Dir    string
+	Mirror bool
+}
+
+// Volume is a volume to mount, sources are tried in order until one of them is found
+type Volume struct {
+	Dest      string
+	UseParent bool
+	Sources   []VolumeSource
+}
+
+// EnvSource is one of the ways to find the env variable, exactly one field must be set
+type EnvSource struct {
+	Variable string
+	Value    string
+	Prefix   string
+	DePrefix bool
+}
+
+// Env is an env variable to pass, sources are tried in order until one of them is found.
+// If there are no sources, the variable by Name is mirrored from the host.
+type Env struct {
+	Name      string
+	CopyValue bool
+	Sensitive bool
+	Sources   []EnvSource
+}
+
+// Discoverer is a user defined discoverer
+type Discoverer struct {
+	Name    string
+	Volumes []Volume
+	Env     []Env
+}
+
+func init() {
+	discover.Register(discover.Discoverer{
+		Name:        "custom",
+		Description: "User defined discoverers from discovery.custom config",
+		Order:       900,
+		Features: func() []discover.Feature {
+			features := []discover.Feature{}
+			custom, err := load()
+			if err != nil {
+				log.Normal.Print(err)
+			}
+			for _, d := range custom {
+				features = append(features, discover.Feature{Name: d.Name, DisableKey: d.Name})
+			}
+			return features
+		},
+		Discover: Discover,
+	})
+}
+
+// load reads discovery.custom config
+func load() ([]Discoverer, error) {
+	var custom []Discoverer
+	if err := viper.UnmarshalKey("discovery.custom", &custom, func(dc *mapstructure.DecoderConfig) {
+		dc.ErrorUnused = true
+	}); err != nil {
+		return nil, failure.Errorf(failure.Config, "%s: %w", config.Describe("discovery.custom"), err)
+	}
+	for idx, d := range custom {
+		if d.Name == "" {
+			return nil, failure.Errorf(failure.Config, "%s: name is required for entry #%d", config.Describe("discovery.custom"), idx+1)
+		}
+	}
+	return custom, nil
+}
+
+// Discover runs user defined discoverers from discovery.custom config
+func Discover(f *discover.Facts) error {
+	custom, err := load()
+	if err != nil {
+		return err
+	}
+	if len(custom) == 0 {
+		return nil
+	}
+
+	log.Debug.Print("Discover custom")
+
+	t := templates.NewData(f.Host, f.Image)
+
+	for _, d := range custom {
+		where := config.Describe("discovery.custom") + ": " + d.Name
+		if f.Disabled(d.Name) {
+			log.Debug.Printf("Custom discoverer %s disabled", d.Name)
+			continue
+		}
+
+		log.Debug.Printf("Discover custom %s", d.Name)
+		f.Origin(d.Name)
+
+		for _, vol := range d.Volumes {
+			if vol.Dest == "" {
+				return failure.Errorf(failure.Config, "%s: volume dest is required", where)
+			}
+			dc := volumes.DiscoveryConfig{UseParent: vol.UseParent}
+			sources := []volumes.Discover{}
+			for _, src := range vol.Sources {
+				var s volumes.Discover
+				set := 0
+				if src.Env != "" {
+					s = &volumes.DiscoverEnvVar{Config: dc, EnvVar: src.Env}
+					set++
+				}
+				if len(src.Exec) > 0 {
+					s = &volumes.DiscoverExec{Config: dc, Args: src.Exec}
+					set++
+				}
+				if src.Dir != "" {
+					dir, err := t.Expand("discovery.custom", src.Dir)
+					if err != nil {
+						return err
+					}
+					s = &volumes.DiscoverDir{Config: dc, Path: dir}
+					set++
+				}
+				if src.Mirror {
+					s = &volumes.DiscoverMirror{Config: dc}
+					set++
+				}
+				if set != 1 {
+					return failure.Errorf(failure.Config, "%s: volume %s: every source must have exactly one of env, exec, dir or mirror", where, vol.Dest)
+				}
+				sources = append(sources, s)
+			}
+			if len(sources) == 0 {
+				sources = append(sources, &volumes.DiscoverMirror{Config: dc})
+			}
+			dest, err := t.Expand("discovery.custom", vol.Dest)
+			if err != nil {
+				return err
+			}
+			if err := f.AddHostMount(dest, sources...); err != nil {
+				return err
+			}
+		}
+
+		for _, en := range d.Env {
+			dc := env.DiscoveryConfig{CopyValue: en.CopyValue, Sensitive: en.Sensitive}
+			sources := []env.Discover{}
+			for _, src := range en.Sources {
+				var s env.Discover
+				set := 0
+				if src.Variable != "" {
+					s = &env.DiscoverVariable{Config: dc, Name: src.Variable}
+					set++
+				}
+				if src.Value != "" {
+					if en.Name == "" {
+						return failure.Errorf(failure.Config, "%s: env name is required for the value source", where)
+					}
+					value, err := t.Expand("discovery.custom", src.Value)
+					if err != nil {
+						return err
+					}
+					s = &env.DiscoverValue{Config: dc, Name: en.Name, Value: value}
+					set++
+				}
+				if src.Prefix != "" {
+					s = &env.DiscoverPrefix{Config: dc, Prefix: src.Prefix, DePrefix: src.DePrefix}
+					set++
+				}
+				if set != 1 {
+					return failure.Errorf(failure.Config, "%s: env %s: every source must have exactly one of variable, value or prefix", where, en.Name)
+				}
+				sources = append(sources, s)
+			}
+			if len(sources) == 0 {
+				if en.Name == "" {
+					return failure.Errorf(failure.Config, "%s: env name or sources are required", where)
+				}
+				sources = append(sources, &env.DiscoverVariable{Config: dc, Name: en.Name})
+			}
+			f.AddEnv(sources...)
+		}
+	}
+
+	return nil
+}
@@ -1,12 +1,10 @@
 package golang
 
 import (
-	"golang.org/x/exp/slices"
-
 	"github.com/plumber-cd/runtainer/discover"
+	"github.com/plumber-cd/runtainer/image"
 	"github.com/plumber-cd/runtainer/log"
 	"github.com/plumber-cd/runtainer/volumes"
-	"github.com/spf13/viper"
 )
 
 const (
@@ -14,29 +12,36 @@ const (
 	defaultGoCache = "~/.cache/go-build"
 )
 
-// Discover specific to Go
-func Discover() {
-	disabled := viper.GetStringSlice("discovery.disabled")
-	if slices.Contains(disabled, "all") || slices.Contains(disabled, "golang") {
-		return
-	}
+func init() {
+	discover.Register(discover.Discoverer{
+		Name:        "golang",
+		Description: "GOPATH and GOCACHE",
+		Order:       40,
+		Applies: func(i image.Image) bool {
+			return i.HasTool("go")
+		},
+		Discover: Discover,
+	})
+}
 
+// Discover specific to Go
+func Discover(f *discover.Facts) error {
 	log.Debug.Print("Discover Go")
 
-	// get what's already calculated by now
-	h, _, _, i, v := discover.GetFromViper()
-
-	v.AddHostMount(h, i, defaultGoPath,
+	if err := f.AddHostMount(defaultGoPath,
 		&volumes.DiscoverEnvVar{EnvVar: "GOPATH"},
 		&volumes.DiscoverExec{Args: []string{"go", "env", "GOPATH"}},
 		&volumes.DiscoverMirror{},
-	)
-	v.AddHostMount(h, i, defaultGoCache,
+	); err != nil {
+		return err
+	}
+	if err := f.AddHostMount(defaultGoCache,
 		&volumes.DiscoverEnvVar{EnvVar: "GOCACHE"},
 		&volumes.DiscoverExec{Args: []string{"go", "env", "GOCACHE"}},
 		&volumes.DiscoverMirror{},
-	)
+	); err != nil {
+		return err
+	}
 
-	log.Debug.Print("Publish to viper")
-	viper.Set("volumes", v)
+	return nil
 }
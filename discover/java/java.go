@@ -1,31 +1,34 @@
 package java
 
 import (
-	"golang.org/x/exp/slices"
-
 	"github.com/plumber-cd/runtainer/discover"
+	"github.com/plumber-cd/runtainer/image"
 	"github.com/plumber-cd/runtainer/log"
 	"github.com/plumber-cd/runtainer/volumes"
-	"github.com/spf13/viper"
 )
 
-// Discover specific to Java
-func Discover() {
-	disabled := viper.GetStringSlice("discovery.disabled")
-	if slices.Contains(disabled, "all") || slices.Contains(disabled, "java") {
-		return
-	}
+func init() {
+	discover.Register(discover.Discoverer{
+		Name:        "java",
+		Description: "~/.m2 or MAVEN_HOME",
+		Order:       50,
+		Applies: func(i image.Image) bool {
+			return i.HasTool("java") || i.HasTool("mvn")
+		},
+		Discover: Discover,
+	})
+}
 
+// Discover specific to Java
+func Discover(f *discover.Facts) error {
 	log.Debug.Print("Discover Java")
 
-	// get what's already calculated by now
-	h, _, _, i, v := discover.GetFromViper()
-
-	v.AddHostMount(h, i, "~/.m2",
+	if err := f.AddHostMount("~/.m2",
 		&volumes.DiscoverEnvVar{EnvVar: "MAVEN_HOME"},
 		&volumes.DiscoverMirror{},
-	)
+	); err != nil {
+		return err
+	}
 
-	log.Debug.Print("Publish to viper")
-	viper.Set("volumes", v)
+	return nil
 }
@@ -0,0 +1,254 @@
+// Package plugins runs external discovery plugins.
+// Plugins are executables named runtainer-discover-<name> on PATH or listed in discovery.plugins config.
+// They receive discovered facts as JSON on StdIn and print additions as JSON to StdOut.
+package plugins
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/plumber-cd/runtainer/discover"
+	"github.com/plumber-cd/runtainer/env"
+	"github.com/plumber-cd/runtainer/host"
+	"github.com/plumber-cd/runtainer/image"
+	"github.com/plumber-cd/runtainer/log"
+	"github.com/plumber-cd/runtainer/provenance"
+	"github.com/plumber-cd/runtainer/volumes"
+	"github.com/spf13/viper"
+)
+
+const (
+	// ProtocolVersion is incremented on breaking changes to Input or Output
+	ProtocolVersion = 1
+
+	prefix         = "runtainer-discover-"
+	defaultTimeout = 10 * time.Second
+)
+
+func init() {
+	discover.Register(discover.Discoverer{
+		Name:        "plugins",
+		Description: "External runtainer-discover-* executables",
+		Order:       1000,
+		Features: func() []discover.Feature {
+			features := []discover.Feature{}
+			for _, p := range Find() {
+				features = append(features, discover.Feature{Name: p.Name, Description: p.Path, DisableKey: p.Name})
+			}
+			return features
+		},
+		Discover:   Discover,
+		Sequential: true,
+	})
+}
+
+// Input is what plugins receive on StdIn
+type Input struct {
+	Version     int             `json:"version"`
+	Host        host.Host       `json:"host"`
+	Image       image.Image     `json:"image"`
+	Environment env.Env         `json:"environment"`
+	Volumes     volumes.Volumes `json:"volumes"`
+	Ports       map[string]int  `json:"ports"`
+}
+
+// Output is what plugins print to StdOut, everything is optional
+type Output struct {
+	// Env to add, null value mirrors the variable from the host
+	Env map[string]*string `json:"env"`
+	// Sensitive env variable names, their values are redacted from the logs
+	Sensitive []string `json:"sensitive"`
+	// Volumes to mount from the host
+	Volumes []volumes.Volume `json:"volumes"`
+	// Ports to forward, local to remote
+	Ports map[string]int `json:"ports"`
+	// Secrets to inject, same format as --secret-env and --secret-volume
+	Secrets struct {
+		Env     []string `json:"env"`
+		Volumes []string `json:"volumes"`
+	} `json:"secrets"`
+	// PodPatch is a strategic merge patch applied to the pod
+	PodPatch json.RawMessage `json:"podPatch"`
+}
+
+// Plugin is a discovered plugin executable
+type Plugin struct {
+	Name string
+	Path string
+}
+
+// Find returns plugins from discovery.plugins config and PATH, config takes precedence on name clash
+func Find() []Plugin {
+	found := map[string]Plugin{}
+
+	for _, dir := range filepath.SplitList(os.Getenv("PATH")) {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			if entry.IsDir() || !strings.HasPrefix(entry.Name(), prefix) {
+				continue
+			}
+			path := filepath.Join(dir, entry.Name())
+			if _, err := exec.LookPath(path); err != nil {
+				continue
+			}
+			name := strings.TrimSuffix(strings.TrimPrefix(entry.Name(), prefix), filepath.Ext(entry.Name()))
+			// first one on PATH wins, same as the shell would do
+			if _, exists := found[name]; !exists {
+				found[name] = Plugin{Name: name, Path: path}
+			}
+		}
+	}
+
+	for _, path := range viper.GetStringSlice("discovery.plugins") {
+		base := filepath.Base(path)
+		name := strings.TrimSuffix(strings.TrimPrefix(base, prefix), filepath.Ext(base))
+		found[name] = Plugin{Name: name, Path: path}
+	}
+
+	list := make([]Plugin, 0, len(found))
+	for _, p := range found {
+		list = append(list, p)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// Discover runs every plugin in alphabetical order.
+// Failing plugins are reported and skipped, they never fail the run.
+func Discover(f *discover.Facts) error {
+	for _, p := range Find() {
+		if f.Disabled(p.Name) {
+			log.Debug.Printf("Plugin %s disabled", p.Name)
+			continue
+		}
+
+		log.Debug.Printf("Discover plugin %s (%s)", p.Name, p.Path)
+		f.Origin("plugin " + p.Name)
+
+		out, err := run(p, f)
+		if err != nil {
+			log.Normal.Printf("Discovery plugin %s failed, skipping: %s", p.Name, err)
+			continue
+		}
+		apply(p, out, f)
+	}
+
+	return nil
+}
+
+// run executes the plugin with the current facts and parses its output
+func run(p Plugin, f *discover.Facts) (*Output, error) {
+	input := Input{
+		Version:     ProtocolVersion,
+		Host:        f.Host,
+		Image:       f.Image,
+		Environment: f.Env,
+		Volumes:     f.Volumes,
+		Ports:       map[string]int{},
+	}
+	for local, remote := range f.Ports {
+		input.Ports[fmt.Sprint(local)] = remote
+	}
+	in, err := json.Marshal(input)
+	if err != nil {
+		return nil, err
+	}
+
+	timeout := defaultTimeout
+	if t := viper.GetDuration("discovery.pluginTimeout"); t > 0 {
+		timeout = t
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(p.Path)
+	cmd.Dir = f.Host.Cwd
+	cmd.Stdin = bytes.NewReader(in)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	setProcessGroup(cmd)
+
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	// Wait doesn't return until everything holding the pipes exits,
+	// so on timeout kill the whole group and not just the plugin - it might have left a background child behind
+	waited := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			killProcessGroup(cmd)
+		case <-waited:
+		}
+	}()
+	err = cmd.Wait()
+	close(waited)
+	if s := strings.TrimSpace(stderr.String()); s != "" {
+		log.Debug.Printf("Plugin %s StdErr: %s", p.Name, s)
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		return nil, fmt.Errorf("timed out after %s", timeout)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	log.Debug.Printf("Plugin %s StdOut: %s", p.Name, stdout.String())
+	out := &Output{}
+	if err := json.Unmarshal(stdout.Bytes(), out); err != nil {
+		return nil, fmt.Errorf("invalid output: %w", err)
+	}
+	return out, nil
+}
+
+// apply adds plugin output to the facts
+func apply(p Plugin, out *Output, f *discover.Facts) {
+	for _, name := range out.Sensitive {
+		log.MarkSensitive(name)
+	}
+	for name, val := range out.Env {
+		if val == nil {
+			f.AddEnv(&env.DiscoverVariable{Name: name})
+		} else {
+			f.AddEnv(&env.DiscoverValue{Name: name, Value: *val})
+		}
+	}
+
+	for _, vol := range out.Volumes {
+		if vol.Src == "" || vol.Dest == "" {
+			log.Normal.Printf("Discovery plugin %s returned a volume without src or dest, skipping", p.Name)
+			continue
+		}
+		if err := f.AddHostMount(vol.Dest, &volumes.DiscoverDir{Path: vol.Src}); err != nil {
+			log.Normal.Printf("Discovery plugin %s returned a volume that can't be mounted, skipping: %s", p.Name, err)
+		}
+	}
+
+	for local, remote := range out.Ports {
+		var l int
+		if _, err := fmt.Sscan(local, &l); err != nil {
+			log.Normal.Printf("Discovery plugin %s returned invalid port %s, skipping", p.Name, local)
+			continue
+		}
+		f.Ports[l] = remote
+		provenance.Add(provenance.KindPort, local)
+	}
+
+	f.Secrets.Env = append(f.Secrets.Env, out.Secrets.Env...)
+	f.Secrets.Volumes = append(f.Secrets.Volumes, out.Secrets.Volumes...)
+	if len(out.PodPatch) > 0 && string(out.PodPatch) != "null" {
+		f.PodPatches = append(f.PodPatches, out.PodPatch)
+	}
+}
@@ -0,0 +1,18 @@
+//go:build !windows
+
+package plugins
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup starts the plugin in its own process group, so that its children could be killed with it
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// killProcessGroup kills the plugin and everything it started that is still holding its pipes
+func killProcessGroup(cmd *exec.Cmd) {
+	_ = syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
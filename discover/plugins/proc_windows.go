@@ -0,0 +1,15 @@
+//go:build windows
+
+package plugins
+
+import (
+	"os/exec"
+)
+
+// setProcessGroup is a no-op on windows, children are not tracked there
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// killProcessGroup kills the plugin process only
+func killProcessGroup(cmd *exec.Cmd) {
+	_ = cmd.Process.Kill()
+}
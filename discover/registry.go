@@ -0,0 +1,314 @@
+package discover
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/exp/slices"
+
+	"github.com/plumber-cd/runtainer/env"
+	"github.com/plumber-cd/runtainer/failure"
+	"github.com/plumber-cd/runtainer/host"
+	"github.com/plumber-cd/runtainer/image"
+	"github.com/plumber-cd/runtainer/log"
+	"github.com/plumber-cd/runtainer/provenance"
+	"github.com/plumber-cd/runtainer/spec"
+	"github.com/plumber-cd/runtainer/volumes"
+	"github.com/spf13/viper"
+)
+
+// Feature is a part of the discoverer that can be disabled on its own
+type Feature struct {
+	Name        string
+	Description string
+	// DisableKey is what to put in --disable-discovery to disable it, <discoverer>.<feature> by default
+	DisableKey string
+}
+
+// Discoverer is a registered discoverer
+type Discoverer struct {
+	Name        string
+	Description string
+	// Order discoverers run in ascending order, ties are broken by name
+	Order int
+	// Features returns sub-features, it's a func as some discoverers only know them at runtime
+	Features func() []Feature
+	// Applies tells if the discoverer is relevant for the image, nil means any image
+	Applies func(i image.Image) bool
+	// Discover adds whatever it discovers to the facts
+	Discover func(f *Facts) error
+	// Sequential discoverers need facts from every discoverer before them, so they run alone.
+	// Others run concurrently, each only sees Host, Image and its own additions - Env, Ports and Volumes start empty.
+	Sequential bool
+}
+
+// Facts are what's discovered so far, discoverers are adding to them.
+// It is a subset of the spec.RunSpec that discoverers are allowed to change.
+type Facts struct {
+	Host    host.Host
+	Image   image.Image
+	Env     env.Env
+	Ports   env.Ports
+	Volumes volumes.Volumes
+	// Secrets and PodPatches are passed to the backend as-is
+	Secrets    spec.Secrets
+	PodPatches []json.RawMessage
+
+	discoverer string
+	disabled   []string
+
+	// concurrent facts start empty and keep origins aside until merged
+	concurrent bool
+	origin     string
+	origins    []provenance.Record
+	attributed map[string]bool
+}
+
+// Feature tells if the feature of the current discoverer is enabled,
+// and if so - sets it as the origin for everything added after.
+func (f *Facts) Feature(name string) bool {
+	key := f.discoverer + "." + name
+	if slices.Contains(f.disabled, key) {
+		log.Debug.Printf("Discovery %s disabled", key)
+		return false
+	}
+	f.Origin(key)
+	return true
+}
+
+// Origin sets the discoverer by that name as the origin for everything added after
+func (f *Facts) Origin(name string) {
+	if !f.concurrent {
+		provenance.Discoverer(name)
+		return
+	}
+	f.attribute()
+	f.origin = "discoverer " + name
+}
+
+// attribute assigns the current origin to everything added since the last call
+func (f *Facts) attribute() {
+	add := func(kind, name string) {
+		if f.attributed[kind+"/"+name] {
+			return
+		}
+		f.attributed[kind+"/"+name] = true
+		f.origins = append(f.origins, provenance.Record{Kind: kind, Name: name, Origin: f.origin})
+	}
+	for key := range f.Env {
+		add(provenance.KindEnv, key)
+	}
+	for local := range f.Ports {
+		add(provenance.KindPort, strconv.Itoa(local))
+	}
+	for _, v := range f.Volumes.HostMapping {
+		add(provenance.KindVolume, v.Dest)
+	}
+}
+
+// AddEnv adds the variable from the first source that has it, see env.Env.AddEnv
+func (f *Facts) AddEnv(sources ...env.Discover) {
+	if !f.concurrent {
+		f.Env.AddEnv(f.Host, sources...)
+		return
+	}
+	// concurrent discoverers must not touch the global provenance, attribute records the origin instead
+	f.Env.AddEnvUntracked(f.Host, sources...)
+}
+
+// AddHostMount mounts dest from the first source that has it, see volumes.Volumes.AddHostMount
+func (f *Facts) AddHostMount(dest string, sources ...volumes.Discover) error {
+	if !f.concurrent {
+		return f.Volumes.AddHostMount(f.Host, f.Image, dest, sources...)
+	}
+	_, err := f.Volumes.AddHostMountUntracked(f.Host, f.Image, dest, sources...)
+	return err
+}
+
+// fork returns empty facts for the discoverer to run concurrently with others
+func (f *Facts) fork(d Discoverer) *Facts {
+	return &Facts{
+		Host:       f.Host,
+		Image:      f.Image,
+		Env:        env.Env{},
+		Ports:      env.Ports{},
+		Volumes:    volumes.Volumes{ContainerCwd: f.Volumes.ContainerCwd},
+		discoverer: d.Name,
+		disabled:   f.disabled,
+		concurrent: true,
+		origin:     "discoverer " + d.Name,
+		attributed: map[string]bool{},
+	}
+}
+
+// merge adds what the forked facts discovered, same as if the discoverer ran on these facts
+func (f *Facts) merge(from *Facts) {
+	from.attribute()
+	for key, val := range from.Env {
+		f.Env[key] = val
+	}
+	for local, remote := range from.Ports {
+		f.Ports[local] = remote
+	}
+	f.Volumes.HostMapping = append(f.Volumes.HostMapping, from.Volumes.HostMapping...)
+	f.Secrets.Env = append(f.Secrets.Env, from.Secrets.Env...)
+	f.Secrets.Volumes = append(f.Secrets.Volumes, from.Secrets.Volumes...)
+	f.PodPatches = append(f.PodPatches, from.PodPatches...)
+	for _, r := range from.origins {
+		provenance.AddWithOrigin(r.Kind, r.Name, r.Origin)
+	}
+}
+
+// Disabled tells if the key is listed in discovery.disabled,
+// for features with custom DisableKey.
+func (f *Facts) Disabled(key string) bool {
+	return slices.Contains(f.disabled, key)
+}
+
+var (
+	registryMutex sync.RWMutex
+	registry      = []Discoverer{}
+)
+
+// Register adds the discoverer to the registry, usually from the init func of its package
+func Register(d Discoverer) {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+	for _, r := range registry {
+		if r.Name == d.Name {
+			log.Normal.Panicf("Discoverer %s registered twice", d.Name)
+		}
+	}
+	registry = append(registry, d)
+}
+
+// Registered returns all registered discoverers in the order they run
+func Registered() []Discoverer {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+	list := append([]Discoverer{}, registry...)
+	sort.SliceStable(list, func(i, j int) bool {
+		if list[i].Order != list[j].Order {
+			return list[i].Order < list[j].Order
+		}
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
+
+// FeaturesOf returns features of the discoverer with default DisableKey filled in
+func FeaturesOf(d Discoverer) []Feature {
+	if d.Features == nil {
+		return nil
+	}
+	features := d.Features()
+	for i := range features {
+		if features[i].DisableKey == "" {
+			features[i].DisableKey = d.Name + "." + features[i].Name
+		}
+	}
+	return features
+}
+
+// Status tells if the discoverer (or feature, by its disable key) is enabled, and if not - why.
+// Image may be nil if it is not known yet, then applicability is not checked.
+func Status(d Discoverer, key string, i *image.Image) (bool, string) {
+	disabled := viper.GetStringSlice("discovery.disabled")
+	origin := provenance.Setting("discovery.disabled")
+	switch {
+	case slices.Contains(disabled, "all"):
+		return false, fmt.Sprintf("all disabled by %s", origin)
+	case slices.Contains(disabled, d.Name):
+		return false, fmt.Sprintf("%s disabled by %s", d.Name, origin)
+	case key != d.Name && slices.Contains(disabled, key):
+		return false, fmt.Sprintf("%s disabled by %s", key, origin)
+	case i != nil && d.Applies != nil && !d.Applies(*i):
+		return false, fmt.Sprintf("not applicable to %s", i.Name)
+	}
+	return true, ""
+}
+
+// DiscoverAll runs every enabled registered discoverer in order, adding to the spec.
+// Consecutive discoverers that are not Sequential make up a batch, they run concurrently
+// and their results are merged in order, so the outcome doesn't depend on which one finished first.
+func DiscoverAll(s *spec.RunSpec) error {
+	facts := &Facts{
+		Host:       s.Host,
+		Image:      s.Image,
+		Env:        s.Env,
+		Ports:      s.Ports,
+		Volumes:    s.Volumes,
+		Secrets:    s.Secrets,
+		PodPatches: s.PodPatches,
+		disabled:   viper.GetStringSlice("discovery.disabled"),
+	}
+
+	batches := [][]Discoverer{}
+	for _, d := range Registered() {
+		if enabled, reason := Status(d, d.Name, &s.Image); !enabled {
+			log.Debug.Printf("Skipping discoverer %s: %s", d.Name, reason)
+			continue
+		}
+		last := len(batches) - 1
+		if d.Sequential || last < 0 || batches[last][0].Sequential {
+			batches = append(batches, []Discoverer{d})
+		} else {
+			batches[last] = append(batches[last], d)
+		}
+	}
+
+	start := time.Now()
+	for _, batch := range batches {
+		if batch[0].Sequential {
+			d := batch[0]
+			facts.discoverer = d.Name
+			provenance.Discoverer(d.Name)
+			if err := timed(d, facts); err != nil {
+				return err
+			}
+		} else {
+			results := make([]*Facts, len(batch))
+			errs := make([]error, len(batch))
+			var wg sync.WaitGroup
+			for idx, d := range batch {
+				results[idx] = facts.fork(d)
+				wg.Add(1)
+				go func(idx int, d Discoverer) {
+					defer wg.Done()
+					errs[idx] = timed(d, results[idx])
+				}(idx, d)
+			}
+			wg.Wait()
+			for idx, r := range results {
+				if errs[idx] != nil {
+					return errs[idx]
+				}
+				facts.merge(r)
+			}
+		}
+	}
+	log.Debug.Printf("Discovery took %s", time.Since(start))
+
+	s.Env = facts.Env
+	s.Ports = facts.Ports
+	s.Volumes = facts.Volumes
+	s.Secrets = facts.Secrets
+	s.PodPatches = facts.PodPatches
+	return nil
+}
+
+// timed runs the discoverer and logs how long it took
+func timed(d Discoverer, f *Facts) error {
+	log.Debug.Printf("Discover %s", d.Name)
+	start := time.Now()
+	err := d.Discover(f)
+	log.Debug.Printf("Discoverer %s took %s", d.Name, time.Since(start))
+	if err != nil {
+		return failure.Errorf(failure.Discovery, "discoverer %s: %w", d.Name, err)
+	}
+	return nil
+}
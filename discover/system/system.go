@@ -4,67 +4,82 @@ import (
 	"os"
 	"path/filepath"
 
-	"golang.org/x/exp/slices"
-
 	"github.com/plumber-cd/runtainer/discover"
 	"github.com/plumber-cd/runtainer/env"
 	"github.com/plumber-cd/runtainer/log"
 	"github.com/plumber-cd/runtainer/volumes"
-	"github.com/spf13/viper"
 )
 
-// Discover specific to OS
-func Discover() {
-	disabled := viper.GetStringSlice("discovery.disabled")
-	if slices.Contains(disabled, "all") || slices.Contains(disabled, "system") {
-		return
-	}
+func init() {
+	discover.Register(discover.Discoverer{
+		Name:        "system",
+		Description: "Common user home directories and SSH agent",
+		Order:       10,
+		Features: func() []discover.Feature {
+			return []discover.Feature{
+				{Name: "local", Description: "~/.local"},
+				{Name: "cache", Description: "~/.cache"},
+				{Name: "ssh", Description: "~/.ssh"},
+				{Name: "gnupg", Description: "~/.gnupg"},
+				{Name: "ssh-auth-sock", Description: "SSH agent socket"},
+			}
+		},
+		Discover: Discover,
+	})
+}
 
+// Discover specific to OS
+func Discover(f *discover.Facts) error {
 	log.Debug.Print("Discover System/OS")
 
-	// get what's already calculated by now
-	h, e, _, i, v := discover.GetFromViper()
-
-	if !slices.Contains(disabled, "system.local") {
-		v.AddHostMount(h, i, "~/.local",
+	if f.Feature("local") {
+		if err := f.AddHostMount("~/.local",
 			&volumes.DiscoverMirror{},
-		)
+		); err != nil {
+			return err
+		}
 	}
-	if !slices.Contains(disabled, "system.cache") {
-		v.AddHostMount(h, i, "~/.cache",
+	if f.Feature("cache") {
+		if err := f.AddHostMount("~/.cache",
 			&volumes.DiscoverMirror{},
-		)
+		); err != nil {
+			return err
+		}
 	}
-	if !slices.Contains(disabled, "system.ssh") {
-		v.AddHostMount(h, i, "~/.ssh",
+	if f.Feature("ssh") {
+		if err := f.AddHostMount("~/.ssh",
 			&volumes.DiscoverMirror{},
-		)
+		); err != nil {
+			return err
+		}
 	}
-	if !slices.Contains(disabled, "system.gnupg") {
-		v.AddHostMount(h, i, "~/.gnupg",
+	if f.Feature("gnupg") {
+		if err := f.AddHostMount("~/.gnupg",
 			&volumes.DiscoverMirror{},
-		)
+		); err != nil {
+			return err
+		}
 	}
 
-	if !slices.Contains(disabled, "system.ssh-auth-sock") {
+	if f.Feature("ssh-auth-sock") {
 		sshAuthSock, okSshAuthSock := os.LookupEnv("SSH_AUTH_SOCK")
 		if okSshAuthSock {
-			e.AddEnv(h, &env.DiscoverValue{
+			f.AddEnv(&env.DiscoverValue{
 				Name:  "SSH_AUTH_SOCK",
 				Value: "/rt-host-ssh-auth-sock/" + filepath.Base(sshAuthSock),
 			})
-			v.AddHostMount(h, i, "/rt-host-ssh-auth-sock",
+			if err := f.AddHostMount("/rt-host-ssh-auth-sock",
 				&volumes.DiscoverEnvVar{
 					Config: volumes.DiscoveryConfig{
 						UseParent: true,
 					},
 					EnvVar: "SSH_AUTH_SOCK",
 				},
-			)
+			); err != nil {
+				return err
+			}
 		}
 	}
 
-	log.Debug.Print("Publish to viper")
-	viper.Set("env", e)
-	viper.Set("volumes", v)
+	return nil
 }
@@ -0,0 +1,188 @@
+package env
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+)
+
+var dotEnvName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.-]*$`)
+
+// Variable is a single variable parsed from the dotenv file, in order of appearance
+type Variable struct {
+	Name  string
+	Value string
+}
+
+// ParseDotEnvFile reads and parses dotenv file
+func ParseDotEnvFile(path string) ([]Variable, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	vars, err := ParseDotEnv(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return vars, nil
+}
+
+// ParseDotEnv parses dotenv syntax.
+// Every line is KEY=VALUE, optionally prefixed with `export `, empty lines and lines starting with # are ignored.
+// Unquoted values are trimmed and anything after ` #` is considered a comment.
+// Single quoted values are taken literally.
+// Double quoted values support \n, \r, \t, \", \\ and \$ escapes.
+// Quoted values can span multiple lines.
+// Unquoted and double quoted values expand $VAR, ${VAR} and ${VAR:-default},
+// looking up variables defined earlier in the same file first, and then the host environment.
+func ParseDotEnv(r io.Reader) ([]Variable, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	vars := []Variable{}
+	defined := map[string]string{}
+	lookup := func(name string) (string, bool) {
+		if v, ok := defined[name]; ok {
+			return v, true
+		}
+		return os.LookupEnv(name)
+	}
+	lines := strings.Split(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n")
+	for i := 0; i < len(lines); i++ {
+		lineNo := i + 1
+		line := strings.TrimSpace(lines[i])
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+
+		split := strings.SplitN(line, "=", 2)
+		if len(split) != 2 {
+			return nil, fmt.Errorf("line %d: expected KEY=VALUE", lineNo)
+		}
+		name := strings.TrimSpace(split[0])
+		if !dotEnvName.MatchString(name) {
+			return nil, fmt.Errorf("line %d: invalid variable name %q", lineNo, name)
+		}
+		value := strings.TrimLeft(split[1], " \t")
+
+		if len(value) > 0 && (value[0] == '\'' || value[0] == '"') {
+			quote := value[0]
+			// quoted value might span multiple lines, keep reading till the closing quote
+			raw := value[1:]
+			for {
+				end := closingQuote(raw, quote)
+				if end >= 0 {
+					if rest := strings.TrimSpace(raw[end+1:]); rest != "" && !strings.HasPrefix(rest, "#") {
+						return nil, fmt.Errorf("line %d: unexpected characters after the closing quote", lineNo)
+					}
+					raw = raw[:end]
+					break
+				}
+				i++
+				if i >= len(lines) {
+					return nil, fmt.Errorf("line %d: unterminated quoted value", lineNo)
+				}
+				raw += "\n" + lines[i]
+			}
+			if quote == '"' {
+				raw = expand(raw, lookup, true)
+			}
+			value = raw
+		} else {
+			if j := strings.Index(value, " #"); j >= 0 {
+				value = value[:j]
+			}
+			value = expand(strings.TrimSpace(value), lookup, false)
+		}
+
+		defined[name] = value
+		vars = append(vars, Variable{Name: name, Value: value})
+	}
+
+	return vars, nil
+}
+
+// closingQuote finds the closing quote, skipping escaped ones in double quoted strings
+func closingQuote(s string, quote byte) int {
+	for i := 0; i < len(s); i++ {
+		if quote == '"' && s[i] == '\\' {
+			i++
+			continue
+		}
+		if s[i] == quote {
+			return i
+		}
+	}
+	return -1
+}
+
+// expand interpolates variables in a single pass, so that the values being substituted are never expanded again.
+// With escapes, backslash escapes are processed as well, and \$ produces a literal $.
+func expand(s string, lookup func(string) (string, bool), escapes bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if escapes && c == '\\' && i+1 < len(s) {
+			i++
+			switch s[i] {
+			case 'n':
+				b.WriteByte('\n')
+			case 'r':
+				b.WriteByte('\r')
+			case 't':
+				b.WriteByte('\t')
+			case '"', '\\', '$':
+				b.WriteByte(s[i])
+			default:
+				b.WriteByte('\\')
+				b.WriteByte(s[i])
+			}
+			continue
+		}
+		if c != '$' || i+1 >= len(s) {
+			b.WriteByte(c)
+			continue
+		}
+
+		if s[i+1] == '{' {
+			end := strings.IndexByte(s[i+2:], '}')
+			if end < 0 {
+				b.WriteByte(c)
+				continue
+			}
+			expr := s[i+2 : i+2+end]
+			name, def, hasDefault := strings.Cut(expr, ":-")
+			if v, ok := lookup(name); ok && (v != "" || !hasDefault) {
+				b.WriteString(v)
+			} else {
+				b.WriteString(def)
+			}
+			i += end + 2
+			continue
+		}
+
+		j := i + 1
+		for j < len(s) && isNameChar(s[j], j == i+1) {
+			j++
+		}
+		if j == i+1 {
+			b.WriteByte(c)
+			continue
+		}
+		v, _ := lookup(s[i+1 : j])
+		b.WriteString(v)
+		i = j - 1
+	}
+	return b.String()
+}
+
+func isNameChar(c byte, first bool) bool {
+	return c == '_' || (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (!first && c >= '0' && c <= '9')
+}
@@ -0,0 +1,157 @@
+package env
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseDotEnv(t *testing.T) {
+	t.Setenv("RT_DOTENV_HOST", "host")
+	t.Setenv("RT_DOTENV_EMPTY", "")
+
+	tests := []struct {
+		name    string
+		input   string
+		want    []Variable
+		wantErr bool
+	}{
+		{
+			name: "empty",
+			want: []Variable{},
+		},
+		{
+			name:  "comments and empty lines",
+			input: "# comment\n\n  # indented comment\nFOO=bar\n",
+			want:  []Variable{{"FOO", "bar"}},
+		},
+		{
+			name:  "order is preserved",
+			input: "B=1\nA=2\nB=3",
+			want:  []Variable{{"B", "1"}, {"A", "2"}, {"B", "3"}},
+		},
+		{
+			name:  "export prefix",
+			input: "export FOO=bar",
+			want:  []Variable{{"FOO", "bar"}},
+		},
+		{
+			name:  "windows line endings",
+			input: "FOO=bar\r\nBAZ=qux\r\n",
+			want:  []Variable{{"FOO", "bar"}, {"BAZ", "qux"}},
+		},
+		{
+			name:  "unquoted value is trimmed",
+			input: "FOO =  bar  ",
+			want:  []Variable{{"FOO", "bar"}},
+		},
+		{
+			name:  "unquoted inline comment",
+			input: "FOO=bar # comment\nBAZ=a#b",
+			want:  []Variable{{"FOO", "bar"}, {"BAZ", "a#b"}},
+		},
+		{
+			name:  "empty value",
+			input: "FOO=\nBAR=''",
+			want:  []Variable{{"FOO", ""}, {"BAR", ""}},
+		},
+		{
+			name:  "value with equals sign",
+			input: "FOO=a=b",
+			want:  []Variable{{"FOO", "a=b"}},
+		},
+		{
+			name:  "single quotes are literal",
+			input: `FOO='$RT_DOTENV_HOST \n # not a comment'`,
+			want:  []Variable{{"FOO", `$RT_DOTENV_HOST \n # not a comment`}},
+		},
+		{
+			name:  "double quotes escapes",
+			input: `FOO="a\nb\tc\"d\\e\$f\x"`,
+			want:  []Variable{{"FOO", "a\nb\tc\"d\\e$f\\x"}},
+		},
+		{
+			name:  "comment after the closing quote",
+			input: `FOO="bar" # comment`,
+			want:  []Variable{{"FOO", "bar"}},
+		},
+		{
+			name:  "multi-line double quoted",
+			input: "FOO=\"line 1\nline 2\"\nBAR=baz",
+			want:  []Variable{{"FOO", "line 1\nline 2"}, {"BAR", "baz"}},
+		},
+		{
+			name:  "multi-line single quoted",
+			input: "FOO='-----BEGIN-----\nabc\n-----END-----'",
+			want:  []Variable{{"FOO", "-----BEGIN-----\nabc\n-----END-----"}},
+		},
+		{
+			name:  "expand from host",
+			input: "FOO=$RT_DOTENV_HOST\nBAR=\"${RT_DOTENV_HOST}-x\"",
+			want:  []Variable{{"FOO", "host"}, {"BAR", "host-x"}},
+		},
+		{
+			name:  "expand from the file takes precedence over host",
+			input: "RT_DOTENV_HOST=file\nFOO=$RT_DOTENV_HOST",
+			want:  []Variable{{"RT_DOTENV_HOST", "file"}, {"FOO", "file"}},
+		},
+		{
+			name:  "expand undefined is empty",
+			input: "FOO=a${RT_DOTENV_UNDEFINED}b$RT_DOTENV_UNDEFINED",
+			want:  []Variable{{"FOO", "ab"}},
+		},
+		{
+			name:  "default value",
+			input: "FOO=${RT_DOTENV_UNDEFINED:-def}\nBAR=${RT_DOTENV_EMPTY:-def}\nBAZ=${RT_DOTENV_HOST:-def}",
+			want:  []Variable{{"FOO", "def"}, {"BAR", "def"}, {"BAZ", "host"}},
+		},
+		{
+			name:  "expanded values are not expanded again",
+			input: "A='$RT_DOTENV_HOST'\nB=$A",
+			want:  []Variable{{"A", "$RT_DOTENV_HOST"}, {"B", "$RT_DOTENV_HOST"}},
+		},
+		{
+			name:  "lone dollar sign",
+			input: "FOO=a$ b$\nBAR=${unterminated",
+			want:  []Variable{{"FOO", "a$ b$"}, {"BAR", "${unterminated"}},
+		},
+		{
+			name:    "missing equals sign",
+			input:   "FOO",
+			wantErr: true,
+		},
+		{
+			name:    "invalid name",
+			input:   "1FOO=bar",
+			wantErr: true,
+		},
+		{
+			name:    "unterminated quote",
+			input:   "FOO=\"bar\nBAZ=qux",
+			wantErr: true,
+		},
+		{
+			name:    "characters after the closing quote",
+			input:   `FOO="bar"baz`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseDotEnv(strings.NewReader(tt.input))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got %v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
@@ -3,27 +3,39 @@ package env
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 
+	"github.com/plumber-cd/runtainer/config"
+	"github.com/plumber-cd/runtainer/failure"
 	"github.com/plumber-cd/runtainer/host"
+	"github.com/plumber-cd/runtainer/image"
 	"github.com/plumber-cd/runtainer/log"
+	"github.com/plumber-cd/runtainer/provenance"
+	"github.com/plumber-cd/runtainer/templates"
+	"github.com/plumber-cd/runtainer/utils"
+	"github.com/spf13/cast"
 	"github.com/spf13/viper"
 )
 
 // DiscoveryConfig various configuration for discovery
 type DiscoveryConfig struct {
 	CopyValue bool
+	// Sensitive marks discovered variables as sensitive regardless of their names,
+	// so their values are redacted from the logs and might be passed via ephemeral secrets.
+	Sensitive bool
 }
 
 func (dc *DiscoveryConfig) string() string {
-	return fmt.Sprintf("DiscoveryConfig{CopyValue: %v}", dc.CopyValue)
+	return fmt.Sprintf("DiscoveryConfig{CopyValue: %v, Sensitive: %v}", dc.CopyValue, dc.Sensitive)
 }
 
 // Discover is an interface for various discoverers
 type Discover interface {
 	// Discover must return true only if anything discovered
 	discover(h host.Host) (found bool, vars map[string]interface{})
+	config() DiscoveryConfig
 }
 
 // DiscoverValue don't even tries to discover anything, just forcibly adds key:val pair.
@@ -37,6 +49,10 @@ func (d *DiscoverValue) string() string {
 	return fmt.Sprintf("DiscoverValue{Name: %s, Value: %s} with %s", d.Name, d.Value, d.Config.string())
 }
 
+func (d *DiscoverValue) config() DiscoveryConfig {
+	return d.Config
+}
+
 func (d *DiscoverValue) discover(_ host.Host) (bool, map[string]interface{}) {
 	log.Debug.Print(d.string())
 	return true, map[string]interface{}{d.Name: d.Value}
@@ -53,9 +69,14 @@ func (d *DiscoverVariable) string() string {
 	return fmt.Sprintf("DiscoverVariable{Name: %s} with %s", d.Name, d.Config.string())
 }
 
+func (d *DiscoverVariable) config() DiscoveryConfig {
+	return d.Config
+}
+
 func (d *DiscoverVariable) discover(_ host.Host) (bool, map[string]interface{}) {
 	log.Debug.Print(d.string())
 	if v, exists := os.LookupEnv(d.Name); exists {
+		log.AddSecretIfSensitive(d.Name, v)
 		if d.Config.CopyValue {
 			log.Debug.Printf("Discovered variable %s=%s", d.Name, v)
 			return true, map[string]interface{}{d.Name: v}
@@ -79,6 +100,10 @@ func (d *DiscoverPrefix) string() string {
 	return fmt.Sprintf("DiscoverPrefix{Prefix: %s, DePrefix: %v} with %s", d.Prefix, d.DePrefix, d.Config.string())
 }
 
+func (d *DiscoverPrefix) config() DiscoveryConfig {
+	return d.Config
+}
+
 func (d *DiscoverPrefix) discover(_ host.Host) (bool, map[string]interface{}) {
 	log.Debug.Print(d.string())
 	m := make(map[string]interface{})
@@ -108,41 +133,123 @@ type Ports map[int]int
 // It will be automatically discovered accordingly to configured discovery sources.
 // Try each source until something found, if reached the end and nothing found - do nothing.
 func (env Env) AddEnv(h host.Host, sources ...Discover) {
+	for _, key := range env.AddEnvUntracked(h, sources...) {
+		provenance.Add(provenance.KindEnv, key)
+	}
+}
+
+// AddEnvUntracked is AddEnv that leaves recording the provenance to the caller, returns names of the added variables.
+func (env Env) AddEnvUntracked(h host.Host, sources ...Discover) []string {
 	for _, source := range sources {
 		found, src := source.discover(h)
 		if found {
+			if source.config().Sensitive {
+				for key := range src {
+					log.MarkSensitive(key)
+				}
+			}
+			registerSecrets(src)
 			log.Debug.Printf("Match found: %s", src)
+			added := make([]string, 0, len(src))
 			for key, val := range src {
 				env[key] = val
+				added = append(added, key)
 				log.Debug.Printf("Added variable %s=%s", key, val)
 			}
-			return
+			return added
+		}
+	}
+	return nil
+}
+
+// registerSecrets registers values of sensitive variables for redaction from the logs.
+// If the value is nil, it is going to be mirrored from the host, so the host value is registered.
+func registerSecrets(vars map[string]interface{}) {
+	for key, val := range vars {
+		if !log.IsSensitive(key) {
+			continue
+		}
+		if val == nil {
+			log.AddSecret(os.Getenv(key))
+		} else if str, ok := val.(string); ok {
+			log.AddSecret(str)
 		}
 	}
 }
 
 // DiscoverEnv use to define RunTainer specific known environment variables
-func DiscoverEnv() {
+// Templates are expanded only in the literal values from the environment config,
+// values from anywhere else might legitimately contain {{ i.e. a docker --format string.
+func DiscoverEnv(h host.Host, i image.Image) (Env, error) {
 	log.Debug.Print("Discover Environment")
 
-	h := viper.Get("host").(host.Host)
-
 	e := make(Env)
 	if en := viper.Get("environment"); en != nil {
 		log.Debug.Print("Load user defined environment settings")
-		e = en.(map[string]interface{})
+		m, ok := en.(map[string]interface{})
+		if !ok {
+			return nil, failure.Errorf(failure.Config, "%s: expected a map of variables, got %T", config.Describe("environment"), en)
+		}
+		t := templates.NewData(h, i)
+		for key, val := range m {
+			// see ApplyRules
+			if key == "rules" {
+				continue
+			}
+			v, err := ResolveValue(key, val)
+			if err != nil {
+				return nil, failure.Errorf(failure.Config, "%s: %w", config.Describe("environment."+key), err)
+			}
+			if str, ok := val.(string); ok {
+				if v, err = t.Expand("environment."+key, str); err != nil {
+					return nil, err
+				}
+			}
+			e[key] = v
+			provenance.AddWithOrigin(provenance.KindEnv, key, provenance.Setting("environment."+key))
+		}
+		registerSecrets(e)
 	}
 
 	// just define soma standard host facts as env variables
+	provenance.SetOrigin("runtainer")
 	e.AddEnv(h, &DiscoverValue{Name: "RT_HOST_NAME", Value: h.Name})
 	e.AddEnv(h, &DiscoverValue{Name: "RT_HOST_USER", Value: h.User})
 	e.AddEnv(h, &DiscoverValue{Name: "RT_HOST_HOME", Value: h.Home})
 	e.AddEnv(h, &DiscoverValue{Name: "RT_HOST_CWD", Value: h.Cwd})
 
 	// now we mirror any env vars that starts with RT_VAR_* and RT_EVAR_* to account for any possible user-defined vars
+	provenance.SetOrigin("env RT_VAR_*")
 	e.AddEnv(h, &DiscoverPrefix{Prefix: "RT_VAR_"})
+	provenance.SetOrigin("env RT_EVAR_*")
 	e.AddEnv(h, &DiscoverPrefix{Prefix: "RT_EVAR_", DePrefix: true})
 
+	envFiles := viper.GetStringSlice("envFiles")
+	if viper.GetBool("dotEnv") {
+		dotEnv := filepath.Join(h.Cwd, ".env")
+		if exists, err := utils.FileExists(dotEnv); err != nil {
+			return nil, failure.Wrap(failure.Discovery, err)
+		} else if exists {
+			log.Debug.Printf("Found %s", dotEnv)
+			envFiles = append([]string{dotEnv}, envFiles...)
+		}
+	}
+	for _, f := range envFiles {
+		if !filepath.IsAbs(f) {
+			f = filepath.Join(h.Cwd, f)
+		}
+		log.Debug.Printf("Parsing --env-file=%s", f)
+		provenance.SetOrigin("env file " + f)
+		vars, err := ParseDotEnvFile(f)
+		if err != nil {
+			return nil, failure.Wrap(failure.Config, err)
+		}
+		for _, v := range vars {
+			e.AddEnv(h, &DiscoverValue{Name: v.Name, Value: v.Value})
+		}
+	}
+
+	provenance.SetOrigin(provenance.Setting("env"))
 	for _, v := range viper.GetStringSlice("env") {
 		log.Debug.Printf("Parsing --env=%s", v)
 		split := strings.SplitN(v, "=", 2)
@@ -152,41 +259,55 @@ func DiscoverEnv() {
 		case 2:
 			e.AddEnv(h, &DiscoverValue{Name: split[0], Value: split[1]})
 		default:
-			log.Normal.Fatalf("Invalid input for --env=%s", v)
+			return nil, failure.Errorf(failure.Config, "Invalid input for --env=%s", v)
 		}
 	}
 
-	log.Debug.Print("Publish to viper")
-	viper.Set("environment", e)
+	return e, nil
 }
 
-// DiscoverPorts
-func DiscoverPorts() {
+// DiscoverPorts reads ports to forward from the config and --port flags
+func DiscoverPorts() (Ports, error) {
 	log.Debug.Print("Discover Ports")
 
 	p := make(Ports)
 	if en := viper.Get("ports"); en != nil {
 		log.Debug.Print("Load user defined ports settings")
-		p = en.(map[int]int)
+		m, ok := en.(map[string]interface{})
+		if !ok {
+			return nil, failure.Errorf(failure.Config, "%s: expected a map of local to remote ports, got %T", config.Describe("ports"), en)
+		}
+		for key, val := range m {
+			local, err := strconv.Atoi(key)
+			if err != nil {
+				return nil, failure.Errorf(failure.Config, "%s: local port must be a number", config.Describe("ports."+key))
+			}
+			remote, err := cast.ToIntE(val)
+			if err != nil {
+				return nil, failure.Errorf(failure.Config, "%s: remote port must be a number, got %v", config.Describe("ports."+key), val)
+			}
+			p[local] = remote
+			provenance.AddWithOrigin(provenance.KindPort, strconv.Itoa(local), provenance.Setting("ports."+key))
+		}
 	}
 
 	for _, port := range viper.GetStringSlice("port") {
 		log.Debug.Printf("Parsing --port=%s", port)
 		portSplit := strings.Split(port, ":")
 		if len(portSplit) != 2 {
-			log.Normal.Fatalf("Invalid input for --port=%s", port)
+			return nil, failure.Errorf(failure.Config, "Invalid input for --port=%s", port)
 		}
 		local, err := strconv.Atoi(portSplit[0])
 		if err != nil {
-			log.Normal.Fatal(err)
+			return nil, failure.Errorf(failure.Config, "Invalid local port in --port=%s", port)
 		}
 		remote, err := strconv.Atoi(portSplit[1])
 		if err != nil {
-			log.Normal.Fatal(err)
+			return nil, failure.Errorf(failure.Config, "Invalid remote port in --port=%s", port)
 		}
 		p[local] = remote
+		provenance.AddWithOrigin(provenance.KindPort, strconv.Itoa(local), provenance.Setting("port"))
 	}
 
-	log.Debug.Print("Publish to viper")
-	viper.Set("ports", p)
+	return p, nil
 }
@@ -0,0 +1,105 @@
+package env
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/spf13/viper"
+
+	"github.com/plumber-cd/runtainer/failure"
+	"github.com/plumber-cd/runtainer/log"
+)
+
+func TestDiscoverPorts(t *testing.T) {
+	log.SetupLog()
+
+	tests := []struct {
+		name    string
+		ports   interface{}
+		port    []string
+		want    Ports
+		wantErr bool
+	}{
+		{
+			name: "nothing",
+			want: Ports{},
+		},
+		{
+			// the way it comes from the config file, used to panic on the type assertion
+			name:  "config map",
+			ports: map[string]interface{}{"8080": 80, "9090": 9090},
+			want:  Ports{8080: 80, 9090: 9090},
+		},
+		{
+			name:  "config map with string values",
+			ports: map[string]interface{}{"8080": "80"},
+			want:  Ports{8080: 80},
+		},
+		{
+			name:  "flags override config map",
+			ports: map[string]interface{}{"8080": 80},
+			port:  []string{"8080:8081", "3000:3000"},
+			want:  Ports{8080: 8081, 3000: 3000},
+		},
+		{
+			name:    "config is not a map",
+			ports:   []interface{}{"8080:80"},
+			wantErr: true,
+		},
+		{
+			name:    "config local port is not a number",
+			ports:   map[string]interface{}{"http": 80},
+			wantErr: true,
+		},
+		{
+			name:    "config remote port is not a number",
+			ports:   map[string]interface{}{"8080": "http"},
+			wantErr: true,
+		},
+		{
+			name:    "flag without remote port",
+			port:    []string{"8080"},
+			wantErr: true,
+		},
+		{
+			name:    "flag local port is not a number",
+			port:    []string{"http:80"},
+			wantErr: true,
+		},
+		{
+			name:    "flag remote port is not a number",
+			port:    []string{"8080:http"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			viper.Reset()
+			defer viper.Reset()
+			if tt.ports != nil {
+				viper.Set("ports", tt.ports)
+			}
+			if tt.port != nil {
+				viper.Set("port", tt.port)
+			}
+
+			got, err := DiscoverPorts()
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got %v", got)
+				}
+				if kind := failure.KindOf(err); kind != failure.Config {
+					t.Errorf("expected %s, got %s: %s", failure.Config, kind, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
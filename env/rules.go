@@ -0,0 +1,120 @@
+package env
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/plumber-cd/runtainer/config"
+	"github.com/plumber-cd/runtainer/failure"
+	"github.com/plumber-cd/runtainer/log"
+	"github.com/plumber-cd/runtainer/provenance"
+	"github.com/spf13/viper"
+)
+
+// rulesKey is where the rules are defined, DiscoverEnv skips it
+const rulesKey = "environment.rules"
+
+// Rules are user defined rules applied to the environment after all the discoverers
+type Rules struct {
+	// PassAll mirrors every host env variable
+	PassAll bool
+	// Include mirrors host env variables with names matching any of these regexes
+	Include []string
+	// Exclude removes variables with names matching any of these regexes, regardless of where they came from
+	Exclude []string
+	// Rename is a list of FROM=TO pairs, applied after Exclude
+	Rename []string
+}
+
+func compileRules(key string, patterns []string) ([]*regexp.Regexp, error) {
+	compiled := make([]*regexp.Regexp, 0, len(patterns))
+	for _, p := range patterns {
+		r, err := regexp.Compile(p)
+		if err != nil {
+			return nil, failure.Errorf(failure.Config, "%s: %w", config.Describe(rulesKey+"."+key), err)
+		}
+		compiled = append(compiled, r)
+	}
+	return compiled, nil
+}
+
+func matchAny(name string, patterns []*regexp.Regexp) bool {
+	for _, p := range patterns {
+		if p.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// ApplyRules applies environment.rules to the discovered environment.
+// Must be called after every discoverer, so that the rules could block anything they added.
+func (e Env) ApplyRules() error {
+	if viper.Get(rulesKey) == nil {
+		return nil
+	}
+
+	log.Debug.Print("Apply environment rules")
+
+	var rules Rules
+	if err := viper.UnmarshalKey(rulesKey, &rules); err != nil {
+		return failure.Errorf(failure.Config, "%s: %w", config.Describe(rulesKey), err)
+	}
+	include, err := compileRules("include", rules.Include)
+	if err != nil {
+		return err
+	}
+	exclude, err := compileRules("exclude", rules.Exclude)
+	if err != nil {
+		return err
+	}
+
+	for _, kv := range os.Environ() {
+		name := strings.SplitN(kv, "=", 2)[0]
+		if _, exists := e[name]; exists {
+			continue
+		}
+		if rules.PassAll || matchAny(name, include) {
+			log.Debug.Printf("Mirroring host variable %s", name)
+			provenance.AddWithOrigin(provenance.KindEnv, name, provenance.Setting(rulesKey))
+			log.AddSecretIfSensitive(name, os.Getenv(name))
+			e[name] = nil
+		}
+	}
+
+	for name := range e {
+		if matchAny(name, exclude) {
+			log.Debug.Printf("Excluding variable %s", name)
+			delete(e, name)
+			provenance.Remove(provenance.KindEnv, name)
+		}
+	}
+
+	for _, pair := range rules.Rename {
+		split := strings.SplitN(pair, "=", 2)
+		if len(split) != 2 || split[0] == "" || split[1] == "" {
+			return failure.Errorf(failure.Config, "%s: expected FROM=TO, got %q", config.Describe(rulesKey+".rename"), pair)
+		}
+		from, to := split[0], split[1]
+		val, exists := e[from]
+		if !exists {
+			continue
+		}
+		log.Debug.Printf("Renaming variable %s to %s", from, to)
+		// mirrored variable is looked up by its name on the host, so it needs an explicit value under the new name
+		if val == nil {
+			val = os.Getenv(from)
+		}
+		if log.IsSensitive(from) {
+			log.MarkSensitive(to)
+		}
+		delete(e, from)
+		e[to] = val
+		provenance.AddWithOrigin(provenance.KindEnv, to, fmt.Sprintf("%s, renamed from %s by %s", provenance.Get(provenance.KindEnv, from), from, provenance.Setting(rulesKey)))
+		provenance.Remove(provenance.KindEnv, from)
+	}
+
+	return nil
+}
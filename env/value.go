@@ -0,0 +1,132 @@
+package env
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/plumber-cd/runtainer/config"
+	"github.com/plumber-cd/runtainer/log"
+)
+
+// SecretKeyRef is a value that is not known on the host and must be taken from the secret key in the cluster
+type SecretKeyRef struct {
+	Name string
+	Key  string
+}
+
+// String implements fmt.Stringer
+func (r SecretKeyRef) String() string {
+	return "fromSecret:" + r.Name + "/" + r.Key
+}
+
+// MarshalJSON keeps the config form, so that the recorded settings could be loaded back
+func (r SecretKeyRef) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]string{"fromSecret": r.Name + "/" + r.Key})
+}
+
+// ResolveValue converts user defined environment value from the config into one of:
+// nil (mirror from the host), string or SecretKeyRef.
+// Scalars are stringified, maps with one of fromFile, fromCommand, fromSecret or default are resolved.
+func ResolveValue(name string, val interface{}) (interface{}, error) {
+	switch v := val.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int:
+		return strconv.Itoa(v), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case uint64:
+		return strconv.FormatUint(v, 10), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case SecretKeyRef:
+		return v, nil
+	case map[string]interface{}:
+		return resolveStructured(name, v)
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, vv := range v {
+			m[fmt.Sprint(k)] = vv
+		}
+		return resolveStructured(name, m)
+	default:
+		return nil, fmt.Errorf("unsupported value type %T", val)
+	}
+}
+
+func resolveStructured(name string, m map[string]interface{}) (interface{}, error) {
+	if len(m) != 1 {
+		return nil, fmt.Errorf("expected exactly one of fromFile, fromCommand, fromSecret or default, got %d keys", len(m))
+	}
+
+	for k, v := range m {
+		switch strings.ToLower(k) {
+		case "fromfile":
+			path, ok := v.(string)
+			if !ok || path == "" {
+				return nil, fmt.Errorf("fromFile must be a path")
+			}
+			// relative paths are relative to the config file that defined them
+			if src := config.Source("environment." + name); src != "" && !filepath.IsAbs(path) {
+				path = filepath.Join(filepath.Dir(src), path)
+			}
+			log.Debug.Printf("Reading env variable %s from file %s", name, path)
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return nil, err
+			}
+			return strings.TrimSuffix(string(data), "\n"), nil
+		case "fromcommand":
+			list, ok := v.([]interface{})
+			if !ok || len(list) == 0 {
+				return nil, fmt.Errorf("fromCommand must be a non-empty list")
+			}
+			args := make([]string, 0, len(list))
+			for _, a := range list {
+				args = append(args, fmt.Sprint(a))
+			}
+			log.Debug.Printf("Reading env variable %s from command %v", name, args)
+			var stderr bytes.Buffer
+			cmd := exec.Command(args[0], args[1:]...)
+			cmd.Stderr = &stderr
+			out, err := cmd.Output()
+			if err != nil {
+				if msg := strings.TrimSpace(stderr.String()); msg != "" {
+					return nil, fmt.Errorf("fromCommand %v: %w: %s", args, err, msg)
+				}
+				return nil, fmt.Errorf("fromCommand %v: %w", args, err)
+			}
+			return strings.TrimRight(string(out), "\r\n"), nil
+		case "fromsecret":
+			ref, ok := v.(string)
+			split := strings.SplitN(ref, "/", 2)
+			if !ok || len(split) != 2 || split[0] == "" || split[1] == "" {
+				return nil, fmt.Errorf("fromSecret must be in the form of name/key")
+			}
+			return SecretKeyRef{Name: split[0], Key: split[1]}, nil
+		case "default":
+			if _, ok := v.(map[string]interface{}); ok {
+				return nil, fmt.Errorf("default must be a scalar")
+			}
+			if _, exists := os.LookupEnv(name); exists {
+				log.Debug.Printf("Env variable %s exists on the host, default ignored", name)
+				return nil, nil
+			}
+			return ResolveValue(name, v)
+		default:
+			return nil, fmt.Errorf("unknown key %q, expected one of fromFile, fromCommand, fromSecret or default", k)
+		}
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,104 @@
+// Package failure classifies errors into kinds, so that the user gets a one-line message and a distinct exit code
+// instead of a stack trace, and the library users could tell what went wrong with errors.As.
+package failure
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Kind of the failure
+type Kind int
+
+const (
+	// Unknown is anything that wasn't classified
+	Unknown Kind = iota
+	// Config is an invalid setting, either in the config file, a flag or an RT_* env variable
+	Config
+	// Discovery is a failure to discover facts about the host
+	Discovery
+	// Image is a failure to pull, start or probe the image
+	Image
+	// Cluster is a failure to reach the cluster or to create, run and cleanup the pod in it
+	Cluster
+	// Timeout is the pod that didn't start or finish in time
+	Timeout
+)
+
+// exit codes stay above what the container commands commonly use, same as docker run uses 125-127 for its own failures
+var exitCodes = map[Kind]int{
+	Timeout:   120,
+	Config:    121,
+	Discovery: 122,
+	Image:     123,
+	Cluster:   124,
+	Unknown:   125,
+}
+
+func (k Kind) String() string {
+	switch k {
+	case Config:
+		return "config error"
+	case Discovery:
+		return "discovery error"
+	case Image:
+		return "image error"
+	case Cluster:
+		return "cluster error"
+	case Timeout:
+		return "timeout"
+	default:
+		return "error"
+	}
+}
+
+// ExitCode the CLI exits with on this kind of failure
+func (k Kind) ExitCode() int {
+	return exitCodes[k]
+}
+
+// Error is an error of a known kind
+type Error struct {
+	Kind Kind
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Wrap marks err with the kind, unless it is nil or already has a kind - the innermost classification is the most accurate
+func Wrap(kind Kind, err error) error {
+	if err == nil || KindOf(err) != Unknown {
+		return err
+	}
+	return &Error{Kind: kind, Err: err}
+}
+
+// Errorf formats a new error of the kind, %w is supported
+func Errorf(kind Kind, format string, args ...interface{}) error {
+	return Wrap(kind, fmt.Errorf(format, args...))
+}
+
+// KindOf returns the kind of err, or Unknown if it wasn't classified
+func KindOf(err error) Kind {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Kind
+	}
+	return Unknown
+}
+
+// Message returns one-line human readable message for err, prefixed with its kind
+func Message(err error) string {
+	return fmt.Sprintf("%s: %s", KindOf(err), err)
+}
+
+// ExitCode returns the exit code for err, see Kind.ExitCode
+func ExitCode(err error) int {
+	return KindOf(err).ExitCode()
+}
@@ -0,0 +1,89 @@
+package failure
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestWrap(t *testing.T) {
+	plain := errors.New("boom")
+
+	tests := []struct {
+		name     string
+		err      error
+		wantKind Kind
+		wantCode int
+	}{
+		{
+			name:     "plain error is unknown",
+			err:      plain,
+			wantKind: Unknown,
+			wantCode: 125,
+		},
+		{
+			name:     "wrapped plain error",
+			err:      Wrap(Cluster, plain),
+			wantKind: Cluster,
+			wantCode: 124,
+		},
+		{
+			name:     "innermost kind wins",
+			err:      Wrap(Cluster, Wrap(Image, plain)),
+			wantKind: Image,
+			wantCode: 123,
+		},
+		{
+			name:     "innermost kind wins through fmt.Errorf",
+			err:      Wrap(Discovery, fmt.Errorf("discoverer foo: %w", Wrap(Config, plain))),
+			wantKind: Config,
+			wantCode: 121,
+		},
+		{
+			name:     "Errorf keeps the kind of %w",
+			err:      Errorf(Cluster, "run: %w", Errorf(Timeout, "too slow")),
+			wantKind: Timeout,
+			wantCode: 120,
+		},
+		{
+			name:     "Errorf without %w",
+			err:      Errorf(Discovery, "run: %s", Errorf(Timeout, "too slow")),
+			wantKind: Discovery,
+			wantCode: 122,
+		},
+		{
+			name:     "classified error wrapped with fmt.Errorf",
+			err:      fmt.Errorf("outer: %w", Wrap(Image, plain)),
+			wantKind: Image,
+			wantCode: 123,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if kind := KindOf(tt.err); kind != tt.wantKind {
+				t.Errorf("expected kind %s, got %s", tt.wantKind, kind)
+			}
+			if code := ExitCode(tt.err); code != tt.wantCode {
+				t.Errorf("expected exit code %d, got %d", tt.wantCode, code)
+			}
+		})
+	}
+}
+
+func TestWrapNil(t *testing.T) {
+	if err := Wrap(Config, nil); err != nil {
+		t.Errorf("expected nil, got %v", err)
+	}
+}
+
+func TestExitCodesAreDistinct(t *testing.T) {
+	seen := map[int]Kind{}
+	for _, kind := range []Kind{Unknown, Config, Discovery, Image, Cluster, Timeout} {
+		code := kind.ExitCode()
+		if other, ok := seen[code]; ok {
+			t.Errorf("%s and %s share exit code %d", kind, other, code)
+		}
+		seen[code] = kind
+	}
+}
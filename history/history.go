@@ -0,0 +1,136 @@
+// Package history keeps track of every runtainer invocation in a JSON Lines file,
+// so that any previous run could be inspected and replayed later.
+package history
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	homedir "github.com/mitchellh/go-homedir"
+
+	"github.com/plumber-cd/runtainer/failure"
+	"github.com/plumber-cd/runtainer/log"
+)
+
+// Entry is a record about a single container run
+type Entry struct {
+	ID string `json:"id"`
+	// Time when the run started
+	Time time.Time `json:"time"`
+	// Argv is a full runtainer command line
+	Argv []string `json:"argv"`
+	// Image is an image name as it was requested
+	Image string `json:"image"`
+	// ImageID is an image reference as it was resolved by the cluster, if known
+	ImageID string `json:"imageId,omitempty"`
+	// Command and Args that were passed to the container
+	Command []string `json:"command,omitempty"`
+	Args    []string `json:"args,omitempty"`
+	// Cwd is a host cwd that was mounted into the container
+	Cwd      string        `json:"cwd"`
+	Duration time.Duration `json:"duration"`
+	ExitCode int           `json:"exitCode"`
+	Pod      string        `json:"pod"`
+	// Settings is the effective settings the container was run with
+	Settings map[string]interface{} `json:"settings"`
+}
+
+// Reference returns an image reference that can be used to run exactly the same image again.
+// Prefers resolved digest when known.
+func (e *Entry) Reference() string {
+	id := e.ImageID
+	if i := strings.Index(id, "://"); i >= 0 {
+		id = id[i+3:]
+	}
+	if strings.Contains(id, "@sha256:") {
+		return id
+	}
+	return e.Image
+}
+
+// Path returns a path to the history file
+func Path() (string, error) {
+	home, err := homedir.Dir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".runtainer", "history.jsonl"), nil
+}
+
+// Append adds a new entry to the end of the history file.
+// The file might contain environment values, so it is only readable by the current user.
+func Append(e Entry) error {
+	p, err := Path()
+	if err != nil {
+		return err
+	}
+	log.Debug.Printf("Recording run %s to %s", e.ID, p)
+
+	if err := os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(p, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(line, '\n'))
+	return err
+}
+
+// Load reads all the entries from the history file in the order they were recorded
+func Load() ([]Entry, error) {
+	p, err := Path()
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(p)
+	if os.IsNotExist(err) {
+		return []Entry{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	entries := []Entry{}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for n := 1; scanner.Scan(); n++ {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			log.Normal.Printf("Skipping corrupted history line %d: %s", n, err)
+			continue
+		}
+		entries = append(entries, e)
+	}
+	return entries, scanner.Err()
+}
+
+// Find looks up an entry by its id
+func Find(id string) (*Entry, error) {
+	entries, err := Load()
+	if err != nil {
+		return nil, err
+	}
+	for i := range entries {
+		if entries[i].ID == id {
+			return &entries[i], nil
+		}
+	}
+	return nil, failure.Errorf(failure.Config, "History entry not found: %s", id)
+}
@@ -0,0 +1,167 @@
+package host
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/term"
+	"k8s.io/client-go/tools/remotecommand"
+
+	"github.com/plumber-cd/runtainer/log"
+)
+
+// CastRecorder records the session to the asciicast v2 file.
+// See https://github.com/asciinema/asciinema/blob/develop/doc/asciicast-v2.md.
+type CastRecorder struct {
+	mutex       sync.Mutex
+	file        *os.File
+	start       time.Time
+	recordInput bool
+}
+
+type castHeader struct {
+	Version   int               `json:"version"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp"`
+	Title     string            `json:"title,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+}
+
+// NewCastRecorder creates the cast file and writes the header to it.
+// Initial terminal size is taken from the host StdOut, if that is a terminal.
+func NewCastRecorder(path, title string, recordInput bool) (*CastRecorder, error) {
+	log.Debug.Printf("Recording session to %s", path)
+
+	width, height := 80, 24
+	if w, h, err := term.GetSize(int(os.Stdout.Fd())); err == nil {
+		width, height = w, h
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &CastRecorder{
+		file:        file,
+		start:       time.Now(),
+		recordInput: recordInput,
+	}
+
+	header, err := json.Marshal(castHeader{
+		Version:   2,
+		Width:     width,
+		Height:    height,
+		Timestamp: r.start.Unix(),
+		Title:     title,
+		Env: map[string]string{
+			"SHELL": os.Getenv("SHELL"),
+			"TERM":  os.Getenv("TERM"),
+		},
+	})
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if _, err := file.Write(append(header, '\n')); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// event writes a single event line
+func (r *CastRecorder) event(kind, data string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	line, err := json.Marshal([]interface{}{time.Since(r.start).Seconds(), kind, data})
+	if err != nil {
+		log.Error.Print(err)
+		return
+	}
+	if _, err := r.file.Write(append(line, '\n')); err != nil {
+		log.Error.Print(err)
+	}
+}
+
+// Output returns a writer that writes to w and records everything as output events
+func (r *CastRecorder) Output(w io.Writer) io.Writer {
+	if w == nil {
+		return nil
+	}
+	return io.MultiWriter(w, &castWriter{recorder: r, kind: "o"})
+}
+
+// Input returns a reader that reads from in and records everything as input events, if input recording is enabled
+func (r *CastRecorder) Input(in io.Reader) io.Reader {
+	if in == nil || !r.recordInput {
+		return in
+	}
+	return io.TeeReader(in, &castWriter{recorder: r, kind: "i"})
+}
+
+// SizeQueue returns a queue that records every terminal size change as resize events
+func (r *CastRecorder) SizeQueue(q remotecommand.TerminalSizeQueue) remotecommand.TerminalSizeQueue {
+	if q == nil {
+		return nil
+	}
+	return castSizeQueue{recorder: r, queue: q}
+}
+
+// Close closes the cast file
+func (r *CastRecorder) Close() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.file.Close()
+}
+
+// castWriter records everything written as events of the kind.
+// Incomplete UTF-8 sequence at the end of the write is held till the next write, as events must be valid UTF-8 strings.
+type castWriter struct {
+	recorder *CastRecorder
+	kind     string
+	pending  []byte
+}
+
+func (w *castWriter) Write(p []byte) (int, error) {
+	data := append(w.pending, p...)
+
+	// find where the last incomplete rune starts, if any
+	cut := len(data)
+	for i := len(data) - 1; i >= 0 && i >= len(data)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(data[i]) {
+			if !utf8.FullRune(data[i:]) {
+				cut = i
+			}
+			break
+		}
+	}
+
+	w.pending = append([]byte{}, data[cut:]...)
+	if cut > 0 {
+		w.recorder.event(w.kind, string(data[:cut]))
+	}
+	return len(p), nil
+}
+
+type castSizeQueue struct {
+	recorder *CastRecorder
+	queue    remotecommand.TerminalSizeQueue
+}
+
+func (q castSizeQueue) Next() *remotecommand.TerminalSize {
+	size := q.queue.Next()
+	if size != nil {
+		q.recorder.event("r", fmt.Sprintf("%dx%d", size.Width, size.Height))
+	}
+	return size
+}
@@ -0,0 +1,171 @@
+package host
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/plumber-cd/runtainer/failure"
+	"github.com/plumber-cd/runtainer/log"
+)
+
+const (
+	diagnoseTimeout  = 10 * time.Second
+	diagnoseEvents   = 10
+	diagnoseLogLines = 20
+)
+
+// fatalWaitingReasons are container waiting reasons that will not resolve by themselves, with the kind of failure they are
+var fatalWaitingReasons = map[string]failure.Kind{
+	"ErrImagePull":               failure.Image,
+	"ImagePullBackOff":           failure.Image,
+	"InvalidImageName":           failure.Image,
+	"ErrImageNeverPull":          failure.Image,
+	"CreateContainerConfigError": failure.Config,
+	"CreateContainerError":       failure.Cluster,
+	"RunContainerError":          failure.Cluster,
+	"CrashLoopBackOff":           failure.Cluster,
+}
+
+// checkPodStart returns an error if the pod is stuck and will never start on its own
+func checkPodStart(pod *v1.Pod) error {
+	for _, c := range pod.Status.Conditions {
+		if c.Type == v1.PodScheduled && c.Status == v1.ConditionFalse && c.Reason == v1.PodReasonUnschedulable {
+			return failure.Errorf(failure.Cluster, "pod %s can't be scheduled: %s", pod.Name, c.Message)
+		}
+	}
+
+	statuses := append(append([]v1.ContainerStatus{}, pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...)
+	for _, s := range statuses {
+		w := s.State.Waiting
+		if w == nil {
+			continue
+		}
+		if kind, fatal := fatalWaitingReasons[w.Reason]; fatal {
+			if w.Message == "" {
+				return failure.Errorf(kind, "container %s can't start: %s", s.Name, w.Reason)
+			}
+			return failure.Errorf(kind, "container %s can't start: %s: %s", s.Name, w.Reason, w.Message)
+		}
+	}
+
+	return nil
+}
+
+// failedUnexplained tells if the pod failed for a reason the container exit code doesn't explain, i.e. it was evicted.
+// OOMKilled and a normal non-zero exit of the container are reported on their own.
+func failedUnexplained(pod *v1.Pod, container string) bool {
+	if pod == nil || pod.Status.Phase != v1.PodFailed {
+		return false
+	}
+	for _, s := range pod.Status.ContainerStatuses {
+		if s.Name != container {
+			continue
+		}
+		if t := s.State.Terminated; t != nil && (t.Reason == oomKilled || t.ExitCode != 0) {
+			return false
+		}
+	}
+	return true
+}
+
+// diagnoseUnexplained diagnoses options.Pod if it failed for a reason the container exit code doesn't explain
+func diagnoseUnexplained(options *PodOptions) {
+	if failedUnexplained(options.Pod, options.Container) {
+		diagnose(options.Clientset, options.Pod, options.Container)
+	}
+}
+
+// describeState returns a one-line summary of the container state
+func describeState(s v1.ContainerState) string {
+	switch {
+	case s.Waiting != nil:
+		return strings.TrimSuffix(fmt.Sprintf("waiting (%s): %s", s.Waiting.Reason, s.Waiting.Message), ": ")
+	case s.Running != nil:
+		return fmt.Sprintf("running since %s", s.Running.StartedAt.Local().Format(time.RFC3339))
+	case s.Terminated != nil:
+		return strings.TrimSuffix(
+			fmt.Sprintf("terminated (%s, exit code %d): %s", s.Terminated.Reason, s.Terminated.ExitCode, s.Terminated.Message),
+			": ",
+		)
+	default:
+		return "unknown"
+	}
+}
+
+// diagnose prints everything that might explain why the pod failed:
+// pod status and scheduling messages, container statuses, pod events and last log lines of the container.
+// It is best effort, anything that can't be fetched is skipped.
+func diagnose(clientset *kubernetes.Clientset, pod *v1.Pod, container string) {
+	ctx, cancel := context.WithTimeout(context.Background(), diagnoseTimeout)
+	defer cancel()
+
+	// the pod we have might be stale
+	if p, err := clientset.CoreV1().Pods(pod.Namespace).Get(ctx, pod.Name, metav1.GetOptions{}); err == nil {
+		pod = p
+	} else {
+		log.Debug.Printf("Failed to refresh pod %s: %s", pod.Name, err)
+	}
+
+	log.Normal.Printf("Pod %s diagnostics:", pod.Name)
+	log.Normal.Printf("  phase: %s", pod.Status.Phase)
+	if pod.Status.Reason != "" || pod.Status.Message != "" {
+		log.Normal.Printf("  reason: %s %s", pod.Status.Reason, pod.Status.Message)
+	}
+
+	for _, c := range pod.Status.Conditions {
+		if c.Status != v1.ConditionTrue && c.Message != "" {
+			log.Normal.Printf("  condition %s: %s", c.Type, c.Message)
+		}
+	}
+
+	statuses := append(append([]v1.ContainerStatus{}, pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...)
+	for _, s := range statuses {
+		log.Normal.Printf("  container %s: %s, restarts: %d", s.Name, describeState(s.State), s.RestartCount)
+	}
+
+	events, err := clientset.CoreV1().Events(pod.Namespace).List(ctx, metav1.ListOptions{
+		FieldSelector: fields.OneTermEqualSelector("involvedObject.name", pod.Name).String(),
+	})
+	if err != nil {
+		log.Debug.Printf("Failed to list events for pod %s: %s", pod.Name, err)
+	} else {
+		items := events.Items
+		sort.SliceStable(items, func(i, j int) bool {
+			return items[i].LastTimestamp.Before(&items[j].LastTimestamp)
+		})
+		if len(items) > diagnoseEvents {
+			items = items[len(items)-diagnoseEvents:]
+		}
+		for _, e := range items {
+			log.Normal.Printf("  event [%s] [%s]: %s", e.Type, e.Reason, e.Message)
+		}
+	}
+
+	tail := int64(diagnoseLogLines)
+	out, err := clientset.CoreV1().Pods(pod.Namespace).GetLogs(pod.Name, &v1.PodLogOptions{
+		Container: container,
+		TailLines: &tail,
+	}).DoRaw(ctx)
+	if err != nil {
+		log.Debug.Printf("Failed to get logs for pod %s: %s", pod.Name, err)
+		return
+	}
+	if len(bytes.TrimSpace(out)) == 0 {
+		return
+	}
+	log.Normal.Printf("  last log lines of %s:", container)
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		log.Normal.Printf("    %s", log.Redact(scanner.Text()))
+	}
+}
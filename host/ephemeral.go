@@ -0,0 +1,96 @@
+package host
+
+import (
+	"context"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/plumber-cd/runtainer/log"
+)
+
+// createEphemeralObjects creates short-lived objects the pod depends on, such as secrets and config maps.
+// They must exist before the pod is created, so that it doesn't fail to start.
+// Returns a cleanup function that deletes everything that was created.
+func createEphemeralObjects(options *PodOptions) (func(), error) {
+	secretsClient := options.Clientset.CoreV1().Secrets(options.Namespace)
+	configMapsClient := options.Clientset.CoreV1().ConfigMaps(options.Namespace)
+
+	cleanups := []func(){}
+	cleanup := func() {
+		for _, c := range cleanups {
+			c()
+		}
+	}
+
+	for _, secret := range options.Secrets {
+		name := secret.ObjectMeta.Name
+		log.Debug.Printf("Creating ephemeral secret %s", name)
+		if _, err := secretsClient.Create(context.TODO(), secret, metav1.CreateOptions{}); err != nil {
+			cleanup()
+			return nil, err
+		}
+		cleanups = append(cleanups, func() {
+			log.Debug.Printf("Deleting ephemeral secret %s", name)
+			if err := secretsClient.Delete(context.TODO(), name, metav1.DeleteOptions{}); err != nil {
+				log.Normal.Printf("Failed cleaning up secret %s: %s", name, err)
+			}
+		})
+	}
+
+	for _, configMap := range options.ConfigMaps {
+		name := configMap.ObjectMeta.Name
+		log.Debug.Printf("Creating ephemeral config map %s", name)
+		if _, err := configMapsClient.Create(context.TODO(), configMap, metav1.CreateOptions{}); err != nil {
+			cleanup()
+			return nil, err
+		}
+		cleanups = append(cleanups, func() {
+			log.Debug.Printf("Deleting ephemeral config map %s", name)
+			if err := configMapsClient.Delete(context.TODO(), name, metav1.DeleteOptions{}); err != nil {
+				log.Normal.Printf("Failed cleaning up config map %s: %s", name, err)
+			}
+		})
+	}
+
+	return cleanup, nil
+}
+
+// adoptEphemeralObjects makes the pod an owner of the ephemeral objects,
+// so that they are garbage collected along with the pod even if runtainer didn't have a chance to clean up.
+func adoptEphemeralObjects(options *PodOptions, pod *v1.Pod) error {
+	owner := metav1.OwnerReference{
+		APIVersion: "v1",
+		Kind:       "Pod",
+		Name:       pod.ObjectMeta.Name,
+		UID:        pod.ObjectMeta.UID,
+	}
+
+	secretsClient := options.Clientset.CoreV1().Secrets(options.Namespace)
+	for _, secret := range options.Secrets {
+		log.Debug.Printf("Setting owner of ephemeral secret %s to pod %s", secret.ObjectMeta.Name, pod.ObjectMeta.Name)
+		s, err := secretsClient.Get(context.TODO(), secret.ObjectMeta.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		s.ObjectMeta.OwnerReferences = append(s.ObjectMeta.OwnerReferences, owner)
+		if _, err := secretsClient.Update(context.TODO(), s, metav1.UpdateOptions{}); err != nil {
+			return err
+		}
+	}
+
+	configMapsClient := options.Clientset.CoreV1().ConfigMaps(options.Namespace)
+	for _, configMap := range options.ConfigMaps {
+		log.Debug.Printf("Setting owner of ephemeral config map %s to pod %s", configMap.ObjectMeta.Name, pod.ObjectMeta.Name)
+		c, err := configMapsClient.Get(context.TODO(), configMap.ObjectMeta.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		c.ObjectMeta.OwnerReferences = append(c.ObjectMeta.OwnerReferences, owner)
+		if _, err := configMapsClient.Update(context.TODO(), c, metav1.UpdateOptions{}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
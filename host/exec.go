@@ -1,6 +1,7 @@
 package host
 
 import (
+	"fmt"
 	"os/exec"
 	"strings"
 
@@ -8,19 +9,20 @@ import (
 )
 
 // Exec exec command on the host and return the output
-func Exec(cmd *exec.Cmd) string {
+func Exec(cmd *exec.Cmd) (string, error) {
 	log.Debug.Printf("Executing: %s", cmd.String())
 
 	out, err := cmd.Output()
 	if err != nil {
-		exitErr, ok := err.(*exec.ExitError)
-		if ok {
-			log.Normal.Print(string(exitErr.Stderr))
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			if msg := strings.TrimSpace(string(exitErr.Stderr)); msg != "" {
+				return "", fmt.Errorf("%s: %w: %s", cmd.String(), err, msg)
+			}
 		}
-		log.Normal.Panic(err)
+		return "", fmt.Errorf("%s: %w", cmd.String(), err)
 	}
 	s := string(out)
 
 	log.Debug.Printf("Output: %s", s)
-	return strings.TrimSpace(s)
+	return strings.TrimSpace(s), nil
 }
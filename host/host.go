@@ -9,6 +9,8 @@ import (
 
 	"github.com/mitchellh/go-homedir"
 	"github.com/mitchellh/mapstructure"
+	"github.com/plumber-cd/runtainer/config"
+	"github.com/plumber-cd/runtainer/failure"
 	"github.com/plumber-cd/runtainer/log"
 	"github.com/spf13/viper"
 )
@@ -24,7 +26,7 @@ type Host struct {
 }
 
 // DiscoverHost discover information about the host
-func DiscoverHost() {
+func DiscoverHost() (Host, error) {
 	log.Debug.Print("Discover host")
 
 	h := Host{}
@@ -32,33 +34,32 @@ func DiscoverHost() {
 		log.Debug.Print("Load user defined host settings")
 		// when read from viper for the first time (i.e. nothing Set it there yet as the struct) it will be a map[string]interface{}
 		// hence we need to convert it to the struct
-		err := mapstructure.Decode(hst, &h)
-		if err != nil {
-			log.Normal.Panic(err)
+		if err := mapstructure.Decode(hst, &h); err != nil {
+			return h, failure.Errorf(failure.Config, "%s: %w", config.Describe("host"), err)
 		}
 	}
 
 	hostName, err := os.Hostname()
 	if err != nil {
-		log.Normal.Panic(err)
+		return h, failure.Wrap(failure.Discovery, err)
 	}
 	h.Name = hostName
 
 	currentUser, err := user.Current()
 	if err != nil {
-		log.Normal.Panic(err)
+		return h, failure.Wrap(failure.Discovery, err)
 	}
 	h.User = currentUser.Username
 
 	if runtime.GOOS != "windows" {
 		log.Debug.Printf("Since the platform is %s, use UID/GID", runtime.GOOS)
 		if id, err := strconv.ParseInt(currentUser.Uid, 10, 64); err != nil {
-			log.Normal.Panic(err)
+			return h, failure.Wrap(failure.Discovery, err)
 		} else {
 			h.UID = id
 		}
 		if id, err := strconv.ParseInt(currentUser.Gid, 10, 64); err != nil {
-			log.Normal.Panic(err)
+			return h, failure.Wrap(failure.Discovery, err)
 		} else {
 			h.GID = id
 		}
@@ -66,7 +67,7 @@ func DiscoverHost() {
 
 	home, err := homedir.Dir()
 	if err != nil {
-		log.Normal.Panic(err)
+		return h, failure.Wrap(failure.Discovery, err)
 	}
 	h.Home = home
 
@@ -75,19 +76,18 @@ func DiscoverHost() {
 		log.Debug.Printf("Use user provided cwd %s", d)
 		h.Cwd, err = filepath.Abs(d)
 		if err != nil {
-			log.Normal.Panic(err)
+			return h, failure.Errorf(failure.Config, "dir %s: %w", d, err)
 		}
 	} else {
 		log.Debug.Print("Use actual cwd")
 
 		cwd, err := os.Getwd()
 		if err != nil {
-			log.Normal.Panic(err)
+			return h, failure.Wrap(failure.Discovery, err)
 		}
 
 		h.Cwd = cwd
 	}
 
-	log.Debug.Print("Publish to viper")
-	viper.Set("host", h)
+	return h, nil
 }
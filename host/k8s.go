@@ -2,6 +2,7 @@ package host
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"golang.org/x/term"
 	"io"
@@ -30,12 +31,23 @@ import (
 	uexec "k8s.io/client-go/util/exec"
 	"k8s.io/kubectl/pkg/cmd/exec"
 
+	"github.com/plumber-cd/runtainer/failure"
 	"github.com/plumber-cd/runtainer/log"
 	"github.com/plumber-cd/runtainer/utils"
 )
 
 const serviceAccountNamespace = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
 
+const (
+	// pod status reason when it ran longer than activeDeadlineSeconds
+	deadlineExceeded = "DeadlineExceeded"
+	oomKilled        = "OOMKilled"
+	// same as the shell reports for the process killed with SIGKILL
+	oomKilledExitCode = 137
+	// how long to wait for the logs stream to end after the pod finished
+	logsDrainTimeout = 10 * time.Second
+)
+
 type PodRunMode string
 
 const (
@@ -45,18 +57,46 @@ const (
 )
 
 type PodOptions struct {
-	Config    *rest.Config
-	Clientset *kubernetes.Clientset
-	Namespace string
-	PodSpec   *v1.Pod
-	Container string
-	Mode      PodRunMode
-	ExecCmd   []string
-	Stdin     io.Reader
-	Stdout    io.Writer
-	Stderr    io.Writer
-	Tty       bool
-	Ports     map[int]int
+	// Context cancels the run by deleting the pod, defaults to context.Background()
+	Context context.Context
+	// StartupTimeout limits how long to wait for the pod to be scheduled, pull the image and start, 0 to wait forever
+	StartupTimeout time.Duration
+	// Timeout limits the total runtime of the pod, 0 for no limit
+	Timeout time.Duration
+	// ProgressOut is where to draw the status line while waiting for the pod to start, nil to disable.
+	// It is separate from the pod streams, as those might be captured. Not drawn in --quiet mode or if it is not a terminal.
+	ProgressOut io.Writer
+	Config      *rest.Config
+	Clientset   *kubernetes.Clientset
+	Namespace   string
+	PodSpec     *v1.Pod
+	Container   string
+	Mode        PodRunMode
+	ExecCmd     []string
+	Stdin       io.Reader
+	Stdout      io.Writer
+	Stderr      io.Writer
+	Tty         bool
+	Ports       map[int]int
+	Watch       *WatchOptions
+	Recorder    *CastRecorder
+	// Secrets and ConfigMaps are ephemeral objects to be created before and deleted after the pod
+	Secrets    []*v1.Secret
+	ConfigMaps []*v1.ConfigMap
+	// Pod is populated by ExecPod with the pod as it was last observed
+	Pod *v1.Pod
+}
+
+var (
+	kubeConfigOverride    *rest.Config
+	kubeNamespaceOverride string
+)
+
+// SetKubeConfig makes GetKubeClient use this config and namespace instead of discovering them, i.e. when runtainer is embedded.
+// Nil config and empty namespace bring the discovery back.
+func SetKubeConfig(config *rest.Config, namespace string) {
+	kubeConfigOverride = config
+	kubeNamespaceOverride = namespace
 }
 
 func GetKubeClient() (
@@ -65,9 +105,16 @@ func GetKubeClient() (
 	namespace string,
 	err error,
 ) {
+	defer func() {
+		err = failure.Wrap(failure.Cluster, err)
+	}()
+
 	namespace = v1.NamespaceDefault
 
-	if k8sPort := os.Getenv("KUBERNETES_PORT"); k8sPort != "" {
+	if kubeConfigOverride != nil {
+		log.Debug.Printf("Using provided kube config")
+		config = kubeConfigOverride
+	} else if k8sPort := os.Getenv("KUBERNETES_PORT"); k8sPort != "" {
 		log.Debug.Printf("Using in-cluster authentication")
 		config, err = rest.InClusterConfig()
 		if err != nil {
@@ -109,6 +156,11 @@ func GetKubeClient() (
 		log.Debug.Printf("Context namespace detected: %s", namespace)
 	}
 
+	if kubeNamespaceOverride != "" {
+		log.Debug.Printf("Using provided namespace %s", kubeNamespaceOverride)
+		namespace = kubeNamespaceOverride
+	}
+
 	clientset, err = kubernetes.NewForConfig(config)
 	if err != nil {
 		return
@@ -117,28 +169,94 @@ func GetKubeClient() (
 	return
 }
 
+// ExecPod creates the pod, runs it accordingly to the mode and deletes it.
+// Non-zero exit code of the container is returned as exec.CodeExitError, context errors are returned as-is,
+// anything else is a failure.Cluster error.
 func ExecPod(options *PodOptions) error {
+	parent := options.Context
+	if parent == nil {
+		parent = context.Background()
+	}
+	ctx := parent
+	if options.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(parent, options.Timeout)
+		defer cancel()
+	}
+
+	err := execPod(ctx, options)
+	if _, ok := err.(uexec.CodeExitError); ok {
+		return err
+	}
+	if errors.Is(err, context.DeadlineExceeded) && parent.Err() == nil {
+		return failure.Errorf(failure.Timeout, "pod %s did not finish within %s", options.PodSpec.ObjectMeta.Name, options.Timeout)
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+	return failure.Wrap(failure.Cluster, err)
+}
+
+func execPod(ctx context.Context, options *PodOptions) error {
 	log.Normal.Printf("Running mode: %s", options.Mode)
 
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	podsClient := options.Clientset.CoreV1().Pods(options.Namespace)
 
-	pod, err := podsClient.Create(context.TODO(), options.PodSpec, metav1.CreateOptions{})
+	cleanupEphemeralObjects, err := createEphemeralObjects(options)
 	if err != nil {
 		return err
 	}
-	defer func() {
-		if err := podsClient.Delete(context.TODO(), pod.ObjectMeta.Name, metav1.DeleteOptions{}); err != nil {
-			if err != nil {
+	defer cleanupEphemeralObjects()
+
+	pod, err := podsClient.Create(ctx, options.PodSpec, metav1.CreateOptions{})
+	if err != nil {
+		return err
+	}
+	options.Pod = pod
+	log.SetPod(pod.ObjectMeta.Name)
+	deletePod := sync.Once{}
+	cleanup := func() {
+		deletePod.Do(func() {
+			if err := podsClient.Delete(context.TODO(), pod.ObjectMeta.Name, metav1.DeleteOptions{}); err != nil {
 				log.Normal.Printf("Failed cleaning up pod %s: %s", pod.ObjectMeta.Name, err)
 			}
+		})
+	}
+	defer cleanup()
+
+	// deleting the pod is what interrupts the streams and waits below
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			log.Normal.Printf("Cancelled, deleting pod %s", pod.ObjectMeta.Name)
+			cleanup()
+		case <-done:
 		}
 	}()
 
-	stopEventsWatch := watchPodEvents(options.Clientset, pod)
+	if err := adoptEphemeralObjects(options, pod); err != nil {
+		return err
+	}
+
+	stopEventsWatch := watchPodEvents(options.Clientset, pod, options.ProgressOut)
+	defer stopEventsWatch()
 
 	if options.Mode == PodRunModeModeLogs {
-		waitForPod(options.Clientset, pod, v1.PodRunning, v1.PodSucceeded)
-		stopEventsWatch.CloseOnce()
+		p, err := waitForPod(options.Clientset, pod, options.StartupTimeout, v1.PodRunning, v1.PodSucceeded)
+		stopEventsWatch()
+		if p != nil {
+			options.Pod = p
+		}
+		if err != nil {
+			diagnose(options.Clientset, p, options.Container)
+			return err
+		}
 
 		podOptions := &v1.PodLogOptions{
 			Container: options.Container,
@@ -151,21 +269,57 @@ func ExecPod(options *PodOptions) error {
 			Pods(pod.Namespace).
 			GetLogs(pod.Name, podOptions)
 
-		podLogs, err := req.Stream(context.TODO())
+		podLogs, err := req.Stream(ctx)
 		if err != nil {
 			return err
 		}
+		stdout := options.Stdout
+		if options.Recorder != nil {
+			stdout = options.Recorder.Output(stdout)
+		}
+		defer podLogs.Close()
+		copied := make(chan struct{})
 		go func() {
-			if _, err := io.Copy(os.Stdout, podLogs); err != nil {
-				log.Normal.Panic(err)
+			defer close(copied)
+			if _, err := io.Copy(stdout, podLogs); err != nil && ctx.Err() == nil {
+				log.Error.Printf("Failed streaming pod logs: %s", err)
 			}
 		}()
 
-		return extractExitCode(options.Clientset, pod)
+		err = extractExitCode(options, pod)
+		// the stream ends shortly after the container terminates, let the caller see the tail of the logs
+		select {
+		case <-copied:
+		case <-ctx.Done():
+		case <-time.After(logsDrainTimeout):
+			log.Debug.Printf("Pod %s logs stream did not end in %s, closing it", pod.Name, logsDrainTimeout)
+		}
+		podLogs.Close()
+		<-copied
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		diagnoseUnexplained(options)
+		return err
 	}
 
-	pod = waitForPod(options.Clientset, pod, v1.PodRunning, v1.PodSucceeded)
-	stopEventsWatch.CloseOnce()
+	pod, err = waitForPod(options.Clientset, pod, options.StartupTimeout, v1.PodRunning, v1.PodSucceeded)
+	stopEventsWatch()
+	if pod == nil {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		return fmt.Errorf("pod %s did not start", options.PodSpec.ObjectMeta.Name)
+	}
+	options.Pod = pod
+	if err != nil {
+		diagnose(options.Clientset, pod, options.Container)
+		return err
+	}
+
+	if pod.Status.Phase != v1.PodRunning {
+		return exitedEarly(ctx, options, pod)
+	}
 
 	if pod.Status.Phase == v1.PodRunning {
 		log.Debug.Printf("Pod is still in the running phase - attempt to establish port forwarding....")
@@ -223,6 +377,54 @@ func ExecPod(options *PodOptions) error {
 		}
 	}
 
+	if options.Watch != nil {
+		err = watchPod(ctx, options, pod)
+	} else {
+		err = execOrAttach(options, pod)
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// exitedEarly handles the pod that exited before we could connect to it.
+// All that's left of the container output are the logs, so they are copied to stdout.
+func exitedEarly(ctx context.Context, options *PodOptions, pod *v1.Pod) error {
+	log.Debug.Printf("Pod exited before we could connect to it (%s)", pod.Status.Phase)
+
+	if options.Mode == PodRunModeModeExec {
+		diagnose(options.Clientset, pod, options.Container)
+		return fmt.Errorf("pod %s exited before the command could be executed", pod.Name)
+	}
+
+	if options.Stdout != nil {
+		podLogs, err := options.Clientset.
+			CoreV1().
+			Pods(pod.Namespace).
+			GetLogs(pod.Name, &v1.PodLogOptions{Container: options.Container}).
+			Stream(ctx)
+		if err != nil {
+			return err
+		}
+		defer podLogs.Close()
+
+		stdout := options.Stdout
+		if options.Recorder != nil {
+			stdout = options.Recorder.Output(stdout)
+		}
+		if _, err := io.Copy(stdout, podLogs); err != nil {
+			return err
+		}
+	}
+
+	err := extractExitCode(options, pod)
+	diagnoseUnexplained(options)
+	return err
+}
+
+// execOrAttach connects to the running pod accordingly to the run mode
+func execOrAttach(options *PodOptions, pod *v1.Pod) error {
 	var podOptions runtime.Object
 	method := "POST"
 	req := options.Clientset.CoreV1().RESTClient().Post().
@@ -266,14 +468,47 @@ func ExecPod(options *PodOptions) error {
 	}
 
 	if options.Mode == PodRunModeModeAttach {
-		return extractExitCode(options.Clientset, pod)
+		err := extractExitCode(options, pod)
+		diagnoseUnexplained(options)
+		return err
 	}
 
 	return nil
 }
 
-func waitForPod(clientset *kubernetes.Clientset, pod *v1.Pod, phases ...v1.PodPhase) (result *v1.Pod) {
+// waitForPod waits for the pod to reach any of the phases, or to fail, or to get stuck so that it will never start.
+// The failed pod is returned as-is, stuck pod is returned along with the error explaining why.
+// If it didn't happen within the timeout (unless 0), the pod is returned as it was last observed along with the error.
+// Nil pod means it was deleted, i.e. on cancel.
+func waitForPod(clientset *kubernetes.Clientset, pod *v1.Pod, timeout time.Duration, phases ...v1.PodPhase) (*v1.Pod, error) {
 	stop := utils.NewStopChan()
+	mutex := sync.Mutex{}
+	last := pod
+	finished := false
+	var result *v1.Pod
+	var err error
+
+	// finish records the outcome, whatever comes first wins
+	finish := func(p *v1.Pod, e error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if finished {
+			return
+		}
+		finished = true
+		result, err = p, e
+		stop.CloseOnce()
+	}
+
+	if timeout > 0 {
+		timer := time.AfterFunc(timeout, func() {
+			mutex.Lock()
+			p := last
+			mutex.Unlock()
+			finish(p, failure.Errorf(failure.Timeout, "pod %s did not start within %s", pod.Name, timeout))
+		})
+		defer timer.Stop()
+	}
 
 	watchlist := cache.NewListWatchFromClient(clientset.CoreV1().RESTClient(), "pods", pod.Namespace, fields.Everything())
 	_, controller := cache.NewInformer(watchlist, &v1.Pod{}, time.Second*1, cache.ResourceEventHandlerFuncs{
@@ -285,31 +520,59 @@ func waitForPod(clientset *kubernetes.Clientset, pod *v1.Pod, phases ...v1.PodPh
 				return
 			}
 
+			mutex.Lock()
+			last = newPod
+			mutex.Unlock()
+
 			// if the pod is in expected status - stop watching
 			for _, phase := range phases {
 				if newPod.Status.Phase == phase {
-					result = newPod
-					stop.CloseOnce()
+					finish(newPod, nil)
 					return
 				}
 			}
 
 			if newPod.Status.Phase == v1.PodFailed || newPod.Status.Phase == v1.PodUnknown {
-				log.Normal.Printf("Unexpected pod status %s", newPod.Status.Phase)
-				stop.CloseOnce()
+				log.Debug.Printf("Unexpected pod status %s", newPod.Status.Phase)
+				finish(newPod, nil)
+				return
+			}
+
+			// fail fast rather than wait forever for what is never going to happen
+			if err := checkPodStart(newPod); err != nil {
+				finish(newPod, err)
 				return
 			}
 		},
+		DeleteFunc: func(o interface{}) {
+			if d, ok := o.(cache.DeletedFinalStateUnknown); ok {
+				o = d.Obj
+			}
+			if deleted, ok := o.(*v1.Pod); ok && deleted.Name == pod.Name {
+				log.Debug.Printf("Pod %s was deleted", pod.Name)
+				finish(nil, nil)
+			}
+		},
 	})
 
 	controller.Run(stop.Chan)
-	return
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	return result, err
 }
 
-func watchPodEvents(clientset *kubernetes.Clientset, pod *v1.Pod) *utils.StopChan {
+// watchPodEvents prints pod events, and with progressOut - the status line with what the pod is doing.
+// Returns a function that stops it, safe to call multiple times.
+func watchPodEvents(clientset *kubernetes.Clientset, pod *v1.Pod, progressOut io.Writer) func() {
 	stop := utils.NewStopChan()
 	mutex := sync.Mutex{}
 
+	var p *progress
+	if progressOut != nil {
+		p = startProgress(progressOut)
+	}
+
 	watchlist := cache.NewListWatchFromClient(clientset.CoreV1().RESTClient(), "events", pod.Namespace,
 		fields.Everything())
 	_, controller := cache.NewInformer(
@@ -333,18 +596,24 @@ func watchPodEvents(clientset *kubernetes.Clientset, pod *v1.Pod) *utils.StopCha
 					return
 				}
 
-				log.Normal.Printf(
-					"k8s event [%s] [%s]: %s",
-					e.Type,
-					e.Reason,
-					e.Message,
-				)
+				p.print(func() {
+					log.Normal.Printf(
+						"k8s event [%s] [%s]: %s",
+						e.Type,
+						e.Reason,
+						e.Message,
+					)
+				})
+				p.event(e)
 			},
 		},
 	)
 
 	go controller.Run(stop.Chan)
-	return stop
+	return func() {
+		stop.CloseOnce()
+		p.Stop()
+	}
 }
 
 func startStream(
@@ -375,7 +644,7 @@ func stream(options *PodOptions, url *url.URL, method string) error {
 			in := options.Stdin.(*os.File)
 			oldState, err := term.MakeRaw(int(in.Fd()))
 			if err != nil {
-				log.Normal.Panic(err)
+				return err
 			}
 			defer func() {
 				if err := term.Restore(int(in.Fd()), oldState); err != nil {
@@ -406,21 +675,49 @@ func stream(options *PodOptions, url *url.URL, method string) error {
 		}
 	}
 
+	if options.Recorder != nil {
+		streamOptions.Stdin = options.Recorder.Input(streamOptions.Stdin)
+		streamOptions.Stdout = options.Recorder.Output(streamOptions.Stdout)
+		streamOptions.Stderr = options.Recorder.Output(streamOptions.Stderr)
+		streamOptions.TerminalSizeQueue = options.Recorder.SizeQueue(streamOptions.TerminalSizeQueue)
+	}
+
 	return startStream(method, url, options.Config, streamOptions)
 }
 
-func extractExitCode(clientset *kubernetes.Clientset, pod *v1.Pod) error {
-	pod = waitForPod(clientset, pod, v1.PodSucceeded, v1.PodFailed)
-
+// extractExitCode waits for the pod to finish and returns the container exit code as uexec.CodeExitError.
+// The finished pod is recorded to options.Pod.
+func extractExitCode(options *PodOptions, pod *v1.Pod) error {
 	unknownRcErr := fmt.Errorf("unknown exit code")
 
+	pod, err := waitForPod(options.Clientset, pod, 0, v1.PodSucceeded, v1.PodFailed)
+	if pod != nil {
+		options.Pod = pod
+	}
+	if err != nil {
+		return err
+	}
+	if pod == nil {
+		return unknownRcErr
+	}
+
 	switch pod.Status.Phase {
 	case v1.PodSucceeded:
 		return nil
 	case v1.PodFailed:
+		if pod.Status.Reason == deadlineExceeded {
+			return failure.Errorf(failure.Timeout, "pod %s was stopped by the cluster: %s", pod.Name, pod.Status.Message)
+		}
 		if len(pod.Status.ContainerStatuses) < 1 || pod.Status.ContainerStatuses[0].State.Terminated == nil {
 			return unknownRcErr
 		}
+		if pod.Status.ContainerStatuses[0].State.Terminated.Reason == oomKilled {
+			log.Normal.Print("Container was killed because it ran out of memory (OOMKilled), raise its memory limit or use less memory")
+			return uexec.CodeExitError{
+				Err:  fmt.Errorf("terminated (%s)", oomKilled),
+				Code: oomKilledExitCode,
+			}
+		}
 		rc := pod.Status.ContainerStatuses[0].State.Terminated.ExitCode
 		if rc == 0 {
 			return unknownRcErr
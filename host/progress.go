@@ -0,0 +1,166 @@
+package host
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+
+	"github.com/spf13/viper"
+	"golang.org/x/term"
+)
+
+const progressInterval = 100 * time.Millisecond
+
+var (
+	progressFrames = []string{"|", "/", "-", "\\"}
+	// newer kubelets report the size in the Pulled event, i.e. `... in 3.5s (3.5s including waiting). Image size: 70520347 bytes.`
+	pulledSizeRe = regexp.MustCompile(`Image size: (\d+) bytes`)
+)
+
+// progress is a status line with a spinner, usually on StdErr, showing what the pod is doing while we wait for it to start.
+// Nil progress is valid and does nothing, so that callers don't have to check if it was enabled.
+type progress struct {
+	out    io.Writer
+	mutex  sync.Mutex
+	phase  string
+	detail string
+	since  time.Time
+	frame  int
+	stop   chan struct{}
+	done   chan struct{}
+	once   sync.Once
+}
+
+// startProgress starts drawing the status line to out.
+// Returns nil in --quiet mode or when out is not a terminal, as there is nowhere to draw it.
+func startProgress(out io.Writer) *progress {
+	f, ok := out.(*os.File)
+	if viper.GetBool("quiet") || !ok || !term.IsTerminal(int(f.Fd())) {
+		return nil
+	}
+
+	p := &progress{
+		out:   f,
+		phase: "scheduling",
+		since: time.Now(),
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	go p.run()
+	return p
+}
+
+func (p *progress) run() {
+	defer close(p.done)
+
+	ticker := time.NewTicker(progressInterval)
+	defer ticker.Stop()
+	for {
+		p.mutex.Lock()
+		p.draw()
+		p.mutex.Unlock()
+
+		select {
+		case <-p.stop:
+			p.mutex.Lock()
+			p.clear()
+			p.mutex.Unlock()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// draw must be called with the mutex locked
+func (p *progress) draw() {
+	p.frame = (p.frame + 1) % len(progressFrames)
+	line := fmt.Sprintf("runtainer: %s %s (%s)", progressFrames[p.frame], p.phase, time.Since(p.since).Round(time.Second))
+	if p.detail != "" {
+		line += " " + p.detail
+	}
+	fmt.Fprint(p.out, "\r\033[K"+line)
+}
+
+// clear must be called with the mutex locked
+func (p *progress) clear() {
+	fmt.Fprint(p.out, "\r\033[K")
+}
+
+// set changes the phase, elapsed time is reset when the phase changes
+func (p *progress) set(phase, detail string) {
+	if p == nil {
+		return
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if phase != p.phase {
+		p.since = time.Now()
+	}
+	p.phase, p.detail = phase, detail
+}
+
+// print runs f that prints a line to StdErr, with the status line out of the way.
+// It is drawn again on the next tick.
+func (p *progress) print(f func()) {
+	if p == nil {
+		f()
+		return
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.clear()
+	f()
+}
+
+// event updates the phase accordingly to the pod event
+func (p *progress) event(e *v1.Event) {
+	switch e.Reason {
+	case "Scheduled":
+		p.set("starting", "")
+	case "Pulling":
+		p.set("pulling image", "")
+	case "Pulled":
+		if m := pulledSizeRe.FindStringSubmatch(e.Message); m != nil {
+			if size, err := strconv.ParseInt(m[1], 10, 64); err == nil {
+				p.set("creating container", fmt.Sprintf("pulled %s", humanSize(size)))
+				return
+			}
+		}
+		p.set("creating container", "")
+	case "Created":
+		p.set("starting container", "")
+	case "Started":
+		p.set("running", "")
+	}
+}
+
+// Stop clears the status line, it is safe to call multiple times
+func (p *progress) Stop() {
+	if p == nil {
+		return
+	}
+	p.once.Do(func() {
+		close(p.stop)
+		<-p.done
+	})
+}
+
+// humanSize formats bytes in the units the registries and docker use
+func humanSize(size int64) string {
+	const unit = 1000
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(size)/float64(div), "kMGTPE"[exp])
+}
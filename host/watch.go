@@ -0,0 +1,261 @@
+package host
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	gitignore "github.com/monochromegane/go-gitignore"
+	v1 "k8s.io/api/core/v1"
+	uexec "k8s.io/client-go/util/exec"
+
+	"github.com/plumber-cd/runtainer/log"
+)
+
+const (
+	watchDebounce = 300 * time.Millisecond
+	watchPidFile  = "/tmp/runtainer-watch.pid"
+	// watchWrapper remembers the pid of the command, so that the in-flight run can be killed when files change
+	watchWrapper = `echo $$ > ` + watchPidFile + ` && exec "$@"`
+	// watchKill kills the in-flight command along with everything it started, i.e. test binaries of go test.
+	// The command might share its process group with the container, so the tree is collected from /proc instead.
+	watchKill = `
+tree() {
+	echo "$1"
+	for stat in /proc/[0-9]*/stat; do
+		line=$(cat "$stat" 2>/dev/null) || continue
+		ppid=${line##*) }
+		ppid=${ppid#* }
+		ppid=${ppid%% *}
+		if [ "$ppid" = "$1" ]; then
+			pid=${stat#/proc/}
+			tree "${pid%/stat}"
+		fi
+	done
+}
+kill $(tree "$(cat ` + watchPidFile + `)")
+`
+)
+
+// WatchOptions configures re-running the command in the same pod on file changes
+type WatchOptions struct {
+	// Dir is a directory on the host to watch recursively
+	Dir string
+	// Globs only changes to the files matching at least one of these will trigger a re-run, all files if empty
+	Globs []string
+}
+
+// dirWatcher watches a directory recursively, respecting .gitignore, and sends debounced change notifications
+type dirWatcher struct {
+	options *WatchOptions
+	watcher *fsnotify.Watcher
+	ignore  gitignore.IgnoreMatcher
+	Changes chan struct{}
+}
+
+func newDirWatcher(options *WatchOptions) (*dirWatcher, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	var ignore gitignore.IgnoreMatcher = gitignore.DummyIgnoreMatcher(false)
+	gitignorePath := filepath.Join(options.Dir, ".gitignore")
+	if _, err := os.Stat(gitignorePath); err == nil {
+		log.Debug.Printf("Using %s", gitignorePath)
+		ignore, err = gitignore.NewGitIgnore(gitignorePath, options.Dir)
+		if err != nil {
+			watcher.Close()
+			return nil, err
+		}
+	}
+
+	w := &dirWatcher{
+		options: options,
+		watcher: watcher,
+		ignore:  ignore,
+		Changes: make(chan struct{}, 1),
+	}
+
+	if err := w.addRecursive(options.Dir); err != nil {
+		watcher.Close()
+		return nil, err
+	}
+
+	go w.run()
+	return w, nil
+}
+
+func (w *dirWatcher) ignored(path string, isDir bool) bool {
+	if filepath.Base(path) == ".git" {
+		return true
+	}
+	return path != w.options.Dir && w.ignore.Match(path, isDir)
+}
+
+func (w *dirWatcher) addRecursive(root string) error {
+	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		if w.ignored(path, true) {
+			return filepath.SkipDir
+		}
+		log.Debug.Printf("Watching %s", path)
+		return w.watcher.Add(path)
+	})
+}
+
+func (w *dirWatcher) matches(path string) bool {
+	if len(w.options.Globs) == 0 {
+		return true
+	}
+
+	rel, err := filepath.Rel(w.options.Dir, path)
+	if err != nil {
+		return false
+	}
+	rel = filepath.ToSlash(rel)
+
+	for _, glob := range w.options.Globs {
+		if glob == "**" {
+			return true
+		}
+		if ok, _ := filepath.Match(glob, rel); ok {
+			return true
+		}
+		if ok, _ := filepath.Match(glob, filepath.Base(path)); ok {
+			return true
+		}
+		if strings.HasSuffix(glob, "/**") && strings.HasPrefix(rel, strings.TrimSuffix(glob, "**")) {
+			return true
+		}
+	}
+	return false
+}
+
+func (w *dirWatcher) run() {
+	debounce := time.NewTimer(watchDebounce)
+	debounce.Stop()
+
+	for {
+		select {
+		case event, ok := <-w.watcher.Events:
+			if !ok {
+				return
+			}
+
+			info, err := os.Stat(event.Name)
+			isDir := err == nil && info.IsDir()
+			if w.ignored(event.Name, isDir) {
+				continue
+			}
+
+			if isDir && event.Op&fsnotify.Create == fsnotify.Create {
+				if err := w.addRecursive(event.Name); err != nil {
+					log.Error.Print(err)
+				}
+				continue
+			}
+
+			if !w.matches(event.Name) {
+				continue
+			}
+
+			log.Debug.Printf("Change detected: %s", event)
+			debounce.Reset(watchDebounce)
+		case <-debounce.C:
+			select {
+			case w.Changes <- struct{}{}:
+			default:
+			}
+		case err, ok := <-w.watcher.Errors:
+			if !ok {
+				return
+			}
+			log.Error.Print(err)
+		}
+	}
+}
+
+func (w *dirWatcher) Close() error {
+	return w.watcher.Close()
+}
+
+// watchPod keeps the pod alive and re-executes the command every time watched files change.
+// In-flight run is killed before the next one is started.
+// Returns when interrupted or ctx is done.
+func watchPod(ctx context.Context, options *PodOptions, pod *v1.Pod) error {
+	watcher, err := newDirWatcher(options.Watch)
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	runOptions := *options
+	runOptions.ExecCmd = append([]string{"/bin/sh", "-c", watchWrapper, "runtainer"}, options.ExecCmd...)
+
+	run := func() <-chan error {
+		done := make(chan error, 1)
+		go func() {
+			done <- execOrAttach(&runOptions, pod)
+		}()
+		return done
+	}
+
+	kill := func(inFlight <-chan error) {
+		log.Debug.Print("Killing in-flight run")
+		killOptions := *options
+		killOptions.ExecCmd = []string{"/bin/sh", "-c", watchKill}
+		killOptions.Stdin = nil
+		killOptions.Stdout = new(bytes.Buffer)
+		killOptions.Stderr = new(bytes.Buffer)
+		killOptions.Tty = false
+		if err := execOrAttach(&killOptions, pod); err != nil {
+			log.Error.Printf("Failed to kill in-flight run: %s", err)
+		}
+		<-inFlight
+	}
+
+	log.Normal.Printf("Watching %s for changes, press Ctrl+C to stop", options.Watch.Dir)
+	inFlight := run()
+	for {
+		select {
+		case <-ctx.Done():
+			if inFlight != nil {
+				kill(inFlight)
+			}
+			return nil
+		case err := <-inFlight:
+			inFlight = nil
+			switch e := err.(type) {
+			case nil:
+				log.Normal.Print("Run succeeded, waiting for changes...")
+			case uexec.CodeExitError:
+				log.Normal.Printf("Run failed with exit code %d, waiting for changes...", e.ExitStatus())
+			default:
+				log.Normal.Printf("Run failed: %s, waiting for changes...", err)
+			}
+		case <-watcher.Changes:
+			if inFlight != nil {
+				log.Normal.Print("Change detected, cancelling in-flight run...")
+				kill(inFlight)
+			} else {
+				log.Normal.Print("Change detected, re-running...")
+			}
+			inFlight = run()
+		}
+	}
+}
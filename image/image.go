@@ -2,21 +2,29 @@ package image
 
 import (
 	"bytes"
+	"context"
 	"fmt"
+	"os"
 	"strconv"
 	"strings"
 
+	"golang.org/x/exp/slices"
+
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	kjson "k8s.io/apimachinery/pkg/runtime/serializer/json"
 	"k8s.io/client-go/kubernetes/scheme"
 
+	"github.com/plumber-cd/runtainer/failure"
 	"github.com/plumber-cd/runtainer/host"
 	"github.com/plumber-cd/runtainer/log"
 	"github.com/plumber-cd/runtainer/utils"
 	"github.com/spf13/viper"
 )
 
+// probedTools are looked up on the image PATH, so that discoverers could tell if they apply to the image
+var probedTools = []string{"go", "java", "mvn", "helm", "terraform"}
+
 // Image holds facts about the image
 type Image struct {
 	Name          string
@@ -26,15 +34,24 @@ type Image struct {
 	UID           int64
 	GID           int64
 	Home          string
+	// Tools found on the image PATH out of the probed ones, nil if the image wasn't probed
+	Tools []string
+}
+
+// HasTool tells if the tool is on the image PATH, or might be, if the image wasn't probed
+func (i Image) HasTool(name string) bool {
+	return i.Tools == nil || slices.Contains(i.Tools, name)
 }
 
-// DiscoverImage discover facts about the image
-func DiscoverImage(image string) {
+// DiscoverImage discover facts about the image by running a short-lived probe pod
+func DiscoverImage(ctx context.Context, image string) (Image, error) {
+	log.SetPhase("image-probe")
+	defer log.SetPhase("discovery")
 	log.Debug.Print("Discover image")
 
 	kubeconfig, clientset, namespace, err := host.GetKubeClient()
 	if err != nil {
-		log.Normal.Panic(err)
+		return Image{}, err
 	}
 
 	podName := fmt.Sprintf("runtainer-%s", utils.RandomHex(4))
@@ -68,6 +85,7 @@ func DiscoverImage(image string) {
 	stderr := new(bytes.Buffer)
 
 	podOptions := host.PodOptions{
+		Context:   ctx,
 		Config:    kubeconfig,
 		Clientset: clientset,
 		Namespace: namespace,
@@ -77,48 +95,54 @@ func DiscoverImage(image string) {
 		ExecCmd: []string{
 			"/bin/sh",
 			"-c",
-			"echo $(whoami):$(id -u):$(id -g):$(cd && pwd)",
+			"echo $(whoami):$(id -u):$(id -g):$(cd && pwd):$(for t in " + strings.Join(probedTools, " ") +
+				"; do command -v $t >/dev/null 2>&1 && printf '%s ' $t; done)",
 		},
-		Stdout: stdout,
-		Stderr: stderr,
+		Stdout:         stdout,
+		Stderr:         stderr,
+		StartupTimeout: viper.GetDuration("startup-timeout"),
+		Timeout:        viper.GetDuration("image-probe-timeout"),
+		// the probe is what pulls the image, the output is captured but the status line must be visible
+		ProgressOut: os.Stderr,
 	}
 
 	imageProbeBuf := new(bytes.Buffer)
 	kubeJsonSerializer := kjson.NewYAMLSerializer(kjson.DefaultMetaFactory, scheme.Scheme,
 		scheme.Scheme)
 	if err := kubeJsonSerializer.Encode(&podSpec, imageProbeBuf); err != nil {
-		log.Normal.Panic(err)
+		return Image{}, err
 	}
 	log.Debug.Printf("Image probe pod: %s", imageProbeBuf.String())
 
 	if err := host.ExecPod(&podOptions); err != nil {
-		log.Normal.Println(stderr.String())
-		log.Normal.Panic(err)
+		if s := strings.TrimSpace(stderr.String()); s != "" {
+			return Image{}, failure.Errorf(failure.Image, "image probe failed: %w: %s", err, s)
+		}
+		return Image{}, failure.Errorf(failure.Image, "image probe failed: %w", err)
 	}
 
 	out := strings.TrimSpace(stdout.String())
 	outSplit := strings.Split(out, ":")
-	if len(outSplit) != 4 {
-		log.Normal.Println(stderr.String())
-		log.Normal.Panic(fmt.Errorf("Unexpected output: %s", out))
+	if len(outSplit) != 5 {
+		return Image{}, failure.Errorf(failure.Image, "Unexpected output from image probe: %q", out)
 	}
 	username := outSplit[0]
 	uid, err := strconv.ParseInt(outSplit[1], 10, 64)
 	if err != nil {
-		log.Normal.Panic(err)
+		return Image{}, failure.Wrap(failure.Image, err)
 	}
 	gid, err := strconv.ParseInt(outSplit[2], 10, 64)
 	if err != nil {
-		log.Normal.Panic(err)
+		return Image{}, failure.Wrap(failure.Image, err)
 	}
 	pwd := outSplit[3]
+	tools := strings.Fields(outSplit[4])
 
 	// TODO: for now we assume all containers are Linux
 	os := "linux"
 	pathSeparator := "/"
 
-	log.Debug.Print("Publish to viper")
-	viper.Set("image", Image{
+	return Image{
 		Name:          image,
 		OS:            os,
 		PathSeparator: pathSeparator,
@@ -126,5 +150,25 @@ func DiscoverImage(image string) {
 		UID:           uid,
 		GID:           gid,
 		Home:          pwd,
-	})
+		Tools:         tools,
+	}, nil
+}
+
+// AssumeImage returns assumed facts about the image without probing it,
+// for when the image can't or shouldn't be started, i.e. to explain the settings.
+// Assumes a Linux image running as root.
+func AssumeImage(_ context.Context, image string) (Image, error) {
+	log.Debug.Print("Assume image facts")
+
+	i := Image{
+		Name:          image,
+		OS:            "linux",
+		PathSeparator: "/",
+		User:          "root",
+		UID:           0,
+		GID:           0,
+		Home:          "/root",
+	}
+
+	return i, nil
 }
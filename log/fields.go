@@ -0,0 +1,98 @@
+package log
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	fieldsMutex sync.RWMutex
+	runID       = newRunID()
+	phase       string
+	pod         string
+)
+
+func newRunID() string {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(b)
+}
+
+// RunID returns unique id of this runtainer process, so the log lines from different runs can be told apart
+func RunID() string {
+	return runID
+}
+
+// SetPhase sets the phase of the run (i.e. discovery, run) to be logged with every JSON log line
+func SetPhase(p string) {
+	fieldsMutex.Lock()
+	defer fieldsMutex.Unlock()
+	phase = p
+}
+
+// SetPod sets the pod name to be logged with every JSON log line
+func SetPod(p string) {
+	fieldsMutex.Lock()
+	defer fieldsMutex.Unlock()
+	pod = p
+}
+
+type jsonLine struct {
+	Time    string `json:"time"`
+	Level   string `json:"level"`
+	RunID   string `json:"run_id"`
+	Phase   string `json:"phase,omitempty"`
+	Pod     string `json:"pod,omitempty"`
+	Caller  string `json:"caller,omitempty"`
+	Message string `json:"msg"`
+}
+
+// jsonWriter converts every line written by the stdlib logger into a JSON object.
+// If caller is true, it expects the line to be prefixed with log.Lshortfile.
+// Trim is a prefix to be removed from the message, if any.
+type jsonWriter struct {
+	out    io.Writer
+	level  string
+	caller bool
+	trim   string
+}
+
+func (w *jsonWriter) Write(p []byte) (int, error) {
+	msg := strings.TrimSuffix(string(p), "\n")
+	msg = strings.TrimPrefix(msg, w.trim)
+
+	fieldsMutex.RLock()
+	line := jsonLine{
+		Time:  time.Now().Format(time.RFC3339Nano),
+		Level: w.level,
+		RunID: runID,
+		Phase: phase,
+		Pod:   pod,
+	}
+	fieldsMutex.RUnlock()
+
+	if w.caller {
+		if split := strings.SplitN(msg, ": ", 2); len(split) == 2 {
+			line.Caller = split[0]
+			msg = split[1]
+		}
+	}
+	line.Message = msg
+
+	buf := new(bytes.Buffer)
+	if err := json.NewEncoder(buf).Encode(line); err != nil {
+		return 0, err
+	}
+	if _, err := w.out.Write(buf.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -3,6 +3,9 @@
 // 4 loggers are disabled by default - Debug, Info, Warning and Error.
 // When enabled - they are writing to a file, otherwise they are writing to the void.
 // Regular log mode enables Info, Warning and Error, while debug mode enables all 4 of them.
+// By default the file is ~/.runtainer/logs/<date>.log, it is rotated by size and old files are removed by age.
+// Log file can be written either in plain text or in JSON format, one object per line.
+// Every logger redacts known secrets and values of sensitive env variables, unless --show-secrets was used.
 // Separately there is a logger log.Normal which is for main communication with the user.
 // The tool never prints to the StdOut reserving that channel exclusively
 // to the container in case it's being pipe'd for output processing.
@@ -17,15 +20,25 @@ import (
 	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
-	"sync"
+	"time"
 
+	homedir "github.com/mitchellh/go-homedir"
 	"github.com/spf13/viper"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+const (
+	normalPrefix      = "runtainer: "
+	defaultLogMaxSize = 10
+	defaultLogMaxAge  = 7
 )
 
 var (
-	logFileOnce sync.Once
-	logFile     *os.File
+	logFile     *lumberjack.Logger
+	logFilePath string
 	logWriter   io.Writer
 	Debug       *log.Logger
 	Info        *log.Logger
@@ -34,44 +47,111 @@ var (
 	Normal      *log.Logger
 )
 
+// setting reads the setting from viper if it was set, or falls back to the env variable.
+// Initially, before we read cobra and viper, viper knows nothing,
+// so at least we can use these env variables to configure loggers from the get go.
+func setting(key string) string {
+	if viper.IsSet(key) {
+		return viper.GetString(key)
+	}
+	return os.Getenv("RT_" + strings.ToUpper(strings.ReplaceAll(key, "-", "_")))
+}
+
+func settingInt(key string, def int) int {
+	if s := setting(key); s != "" {
+		if i, err := strconv.Atoi(s); err == nil {
+			return i
+		}
+	}
+	return def
+}
+
+// defaultLogDir returns default location for log files
+func defaultLogDir() string {
+	home, err := homedir.Dir()
+	if err != nil {
+		log.Panic(err)
+	}
+	return filepath.Join(home, ".runtainer", "logs")
+}
+
+// pruneLogDir removes log files older than maxAge days from the dir.
+// Lumberjack only takes care of its own backups, but default file name changes every day.
+func pruneLogDir(dir string, maxAge int) {
+	if maxAge <= 0 {
+		return
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return
+	}
+
+	cutoff := time.Now().Add(-time.Duration(maxAge) * 24 * time.Hour)
+	for _, f := range files {
+		if f.IsDir() || filepath.Ext(f.Name()) != ".log" || f.ModTime().After(cutoff) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, f.Name())); err != nil {
+			log.Print(err)
+		}
+	}
+}
+
+// openLogFile opens (or re-opens, if the path changed) the log file
+func openLogFile() {
+	path := setting("log-file")
+	maxSize := settingInt("log-max-size", defaultLogMaxSize)
+	maxAge := settingInt("log-max-age", defaultLogMaxAge)
+
+	if path == "" {
+		dir := defaultLogDir()
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			log.Panic(err)
+		}
+		pruneLogDir(dir, maxAge)
+		path = filepath.Join(dir, time.Now().Format("2006-01-02")+".log")
+	}
+
+	if logFile != nil && logFilePath == path {
+		logFile.MaxSize = maxSize
+		logFile.MaxAge = maxAge
+		return
+	}
+
+	closeLogFile()
+	logFilePath = path
+	logFile = &lumberjack.Logger{
+		Filename: path,
+		MaxSize:  maxSize,
+		MaxAge:   maxAge,
+	}
+}
+
+func closeLogFile() {
+	if logFile != nil {
+		logFile.Close()
+		logFile = nil
+		logFilePath = ""
+	}
+}
+
 // SetupLog initializes the loggers that are exported by this module.
 // Returns a callback function that when called will close any open resources by the loggers, such as files.
 // It can be called multiple times, when the logging level settings changes.
 // Every instance of a callback function returned can be used and they are equivalent.
 func SetupLog() func() {
-	// initially, before we read cobra and viper, all logs will remain disabled with no possibility to enable it
-	// if we need to debug anything related to cobra/viper routines, at least we can use these env variables to configure loggers from the get go
-	var quiet, debug, info bool
-	if viper.IsSet("debug") {
-		debug = viper.GetBool("debug")
-	} else {
-		debug = strings.ToLower(os.Getenv("RT_DEBUG")) == "true"
-	}
-	if viper.IsSet("quiet") {
-		quiet = viper.GetBool("quiet")
-	} else {
-		quiet = strings.ToLower(os.Getenv("RT_QUIET")) == "true"
-	}
-	if viper.IsSet("log") {
-		info = viper.GetBool("log")
-	} else {
-		info = strings.ToLower(os.Getenv("RT_LOG")) == "true"
-	}
+	debug := strings.ToLower(setting("debug")) == "true"
+	quiet := strings.ToLower(setting("quiet")) == "true"
+	info := strings.ToLower(setting("log")) == "true"
+	jsonFormat := strings.ToLower(setting("log-format")) == "json"
 
 	if debug || info {
-		logFileOnce.Do(func() {
-			var err error
-			logFile, err = os.OpenFile("runtainer.log", os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
-			if err != nil {
-				log.Panic(err)
-			}
-		})
+		openLogFile()
 		logWriter = logFile
 	} else {
 		logWriter = ioutil.Discard
-		if logFile != nil {
-			logFile.Close()
-		}
+		closeLogFile()
 	}
 
 	var debugWriter io.Writer
@@ -81,18 +161,28 @@ func SetupLog() func() {
 		debugWriter = ioutil.Discard
 	}
 
-	logFlags := log.Ldate | log.Ltime | log.Lshortfile
+	if jsonFormat {
+		logFlags := log.Lshortfile
 
-	Debug = log.New(debugWriter, "[DEBUG] ", logFlags)
-	Info = log.New(logWriter, "[INFO] ", logFlags)
-	Warning = log.New(logWriter, "[WARNING] ", logFlags)
-	Error = log.New(logWriter, "[ERROR] ", logFlags)
+		Debug = log.New(&redactWriter{out: &jsonWriter{out: debugWriter, level: "debug", caller: true}}, "", logFlags)
+		Info = log.New(&redactWriter{out: &jsonWriter{out: logWriter, level: "info", caller: true}}, "", logFlags)
+		Warning = log.New(&redactWriter{out: &jsonWriter{out: logWriter, level: "warning", caller: true}}, "", logFlags)
+		Error = log.New(&redactWriter{out: &jsonWriter{out: logWriter, level: "error", caller: true}}, "", logFlags)
+	} else {
+		logFlags := log.Ldate | log.Ltime | log.Lshortfile
+
+		Debug = log.New(&redactWriter{out: debugWriter}, "[DEBUG] ", logFlags)
+		Info = log.New(&redactWriter{out: logWriter}, "[INFO] ", logFlags)
+		Warning = log.New(&redactWriter{out: logWriter}, "[WARNING] ", logFlags)
+		Error = log.New(&redactWriter{out: logWriter}, "[ERROR] ", logFlags)
+	}
 
-	stderrWriter := io.MultiWriter(os.Stderr, Error.Writer())
 	if quiet {
 		Normal = Info
+	} else if jsonFormat {
+		Normal = log.New(&redactWriter{out: io.MultiWriter(os.Stderr, &jsonWriter{out: logWriter, level: "normal", trim: normalPrefix})}, normalPrefix, 0)
 	} else {
-		Normal = log.New(stderrWriter, "runtainer: ", 0)
+		Normal = log.New(io.MultiWriter(&redactWriter{out: os.Stderr}, Error.Writer()), normalPrefix, 0)
 	}
 
 	Debug.Print("Logger initialized")
@@ -100,7 +190,7 @@ func SetupLog() func() {
 	return func() {
 		if logFile != nil {
 			Debug.Print("Closing log file")
-			logFile.Close()
+			closeLogFile()
 		}
 	}
 }
@@ -0,0 +1,143 @@
+package log
+
+import (
+	"io"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	// Redacted is what secrets are replaced with
+	Redacted = "******"
+	// minSecretLength shorter values are never redacted by value, as that would mangle the logs beyond recognition
+	minSecretLength = 4
+)
+
+var (
+	// defaultSensitivePatterns are the env variable name patterns which values are always considered sensitive
+	defaultSensitivePatterns = []string{
+		"*_SECRET*",
+		"*SECRET_*",
+		"*TOKEN*",
+		"*PASSWORD*",
+		"*PASSWD*",
+		"AWS_ACCESS_KEY_ID",
+		"AWS_SECRET_ACCESS_KEY",
+		"AWS_SESSION_TOKEN",
+	}
+
+	// sensitiveValuePatterns are the values that are recognizable as secrets without knowing the name
+	sensitiveValuePatterns = []*regexp.Regexp{
+		// AWS access key id
+		regexp.MustCompile(`\b(A3T[A-Z0-9]|AKIA|ASIA|ABIA|ACCA)[A-Z0-9]{16}\b`),
+	}
+
+	// assignmentPattern is to catch NAME=value in the log messages
+	assignmentPattern = regexp.MustCompile(`\b([A-Za-z_][A-Za-z0-9_]*)=(\S+)`)
+
+	secretsMutex   sync.RWMutex
+	secrets        = map[string]struct{}{}
+	sensitiveNames = map[string]struct{}{}
+)
+
+// ShowSecrets returns true if redaction was explicitly disabled
+func ShowSecrets() bool {
+	return strings.ToLower(setting("show-secrets")) == "true"
+}
+
+// MarkSensitive marks the env variable name as sensitive, regardless of the patterns
+func MarkSensitive(name string) {
+	secretsMutex.Lock()
+	defer secretsMutex.Unlock()
+	sensitiveNames[name] = struct{}{}
+}
+
+// IsSensitive tells if the env variable by that name should be considered sensitive.
+// The name is matched against built-in and user defined patterns (--redact), case-insensitive.
+func IsSensitive(name string) bool {
+	secretsMutex.RLock()
+	_, marked := sensitiveNames[name]
+	secretsMutex.RUnlock()
+	if marked {
+		return true
+	}
+
+	upper := strings.ToUpper(name)
+	for _, pattern := range append(defaultSensitivePatterns, viper.GetStringSlice("redact")...) {
+		if ok, _ := path.Match(strings.ToUpper(pattern), upper); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// AddSecret registers a sensitive value, so it will be redacted from all the logs
+func AddSecret(value string) {
+	if len(value) < minSecretLength {
+		return
+	}
+	secretsMutex.Lock()
+	defer secretsMutex.Unlock()
+	secrets[value] = struct{}{}
+}
+
+// AddSecretIfSensitive registers the value as a secret if the variable name is sensitive
+func AddSecretIfSensitive(name, value string) {
+	if IsSensitive(name) {
+		AddSecret(value)
+	}
+}
+
+// Redact removes all known secrets from the string, unless --show-secrets was used
+func Redact(s string) string {
+	if ShowSecrets() {
+		return s
+	}
+	return Mask(s)
+}
+
+// Mask removes all known secrets from the string regardless of --show-secrets, for what is persisted to disk
+func Mask(s string) string {
+	secretsMutex.RLock()
+	values := make([]string, 0, len(secrets))
+	for v := range secrets {
+		values = append(values, v)
+	}
+	secretsMutex.RUnlock()
+
+	// longer first, in case one secret is a substring of the other
+	sort.Slice(values, func(i, j int) bool { return len(values[i]) > len(values[j]) })
+	for _, v := range values {
+		s = strings.ReplaceAll(s, v, Redacted)
+	}
+
+	for _, pattern := range sensitiveValuePatterns {
+		s = pattern.ReplaceAllString(s, Redacted)
+	}
+
+	return assignmentPattern.ReplaceAllStringFunc(s, func(match string) string {
+		split := assignmentPattern.FindStringSubmatch(match)
+		if IsSensitive(split[1]) {
+			return split[1] + "=" + Redacted
+		}
+		return match
+	})
+}
+
+// redactWriter redacts every write before passing it through.
+// Loggers write every message with a single call, so secrets are never split across writes.
+type redactWriter struct {
+	out io.Writer
+}
+
+func (w *redactWriter) Write(p []byte) (int, error) {
+	if _, err := w.out.Write([]byte(Redact(string(p)))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,117 @@
+// Package provenance keeps track of where every env variable, volume and port came from,
+// i.e. which discoverer, config file, CLI flag or RT_* env variable.
+package provenance
+
+import (
+	"sort"
+	"sync"
+)
+
+const (
+	KindEnv    = "env"
+	KindVolume = "volume"
+	KindPort   = "port"
+	KindPod    = "pod"
+)
+
+// Record is a single tracked item
+type Record struct {
+	Kind   string
+	Name   string
+	Origin string
+}
+
+var (
+	mutex   sync.RWMutex
+	current = "runtainer"
+	records = map[string]map[string]string{}
+
+	settingResolver = func(key string) string { return "setting " + key }
+)
+
+// SetOrigin sets the origin for everything added from now on via Add
+func SetOrigin(origin string) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	current = origin
+}
+
+// Discoverer sets the origin to the discoverer by name, i.e. aws or system.ssh
+func Discoverer(name string) {
+	SetOrigin("discoverer " + name)
+}
+
+// Add records the item with the current origin
+func Add(kind, name string) {
+	mutex.RLock()
+	origin := current
+	mutex.RUnlock()
+	AddWithOrigin(kind, name, origin)
+}
+
+// AddWithOrigin records the item with an explicit origin, the latest record wins
+func AddWithOrigin(kind, name, origin string) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	if records[kind] == nil {
+		records[kind] = map[string]string{}
+	}
+	records[kind][name] = origin
+}
+
+// Remove drops the record, i.e. when the item was excluded
+func Remove(kind, name string) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	delete(records[kind], name)
+}
+
+// Get returns the origin of the item or empty string if unknown
+func Get(kind, name string) string {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	return records[kind][name]
+}
+
+// Records returns everything tracked so far, sorted by kind and name
+func Records() []Record {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	list := []Record{}
+	for kind, names := range records {
+		for name, origin := range names {
+			list = append(list, Record{Kind: kind, Name: name, Origin: origin})
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Kind != list[j].Kind {
+			return list[i].Kind < list[j].Kind
+		}
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
+
+// Reset forgets everything, so that the discovery could run again from scratch
+func Reset() {
+	mutex.Lock()
+	defer mutex.Unlock()
+	current = "runtainer"
+	records = map[string]map[string]string{}
+}
+
+// SetSettingResolver sets the function that tells where the setting value came from.
+// It lives in the cmd package as it needs to know the CLI flags.
+func SetSettingResolver(f func(key string) string) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	settingResolver = f
+}
+
+// Setting returns the origin of the setting, i.e. a CLI flag, a config file or an RT_* env variable
+func Setting(key string) string {
+	mutex.RLock()
+	f := settingResolver
+	mutex.RUnlock()
+	return f(key)
+}
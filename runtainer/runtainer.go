@@ -0,0 +1,212 @@
+// Package runtainer runs anything as a container the same way the runtainer CLI does, for embedding into other tools.
+// Settings are read from the global viper. Unlike the CLI, Run doesn't load config files and RT_* env variables into it,
+// so only Options.Settings and whatever the caller has set in viper apply.
+// Settings and the provenance are global, so runs must not be concurrent.
+package runtainer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/util/exec"
+
+	"github.com/plumber-cd/runtainer/backends/k8s"
+	"github.com/plumber-cd/runtainer/config"
+	"github.com/plumber-cd/runtainer/discover"
+	_ "github.com/plumber-cd/runtainer/discover/aws"
+	_ "github.com/plumber-cd/runtainer/discover/custom"
+	_ "github.com/plumber-cd/runtainer/discover/golang"
+	_ "github.com/plumber-cd/runtainer/discover/helm"
+	_ "github.com/plumber-cd/runtainer/discover/java"
+	_ "github.com/plumber-cd/runtainer/discover/kube"
+	_ "github.com/plumber-cd/runtainer/discover/plugins"
+	_ "github.com/plumber-cd/runtainer/discover/system"
+	_ "github.com/plumber-cd/runtainer/discover/tf"
+	"github.com/plumber-cd/runtainer/env"
+	"github.com/plumber-cd/runtainer/failure"
+	"github.com/plumber-cd/runtainer/host"
+	"github.com/plumber-cd/runtainer/image"
+	"github.com/plumber-cd/runtainer/log"
+	"github.com/plumber-cd/runtainer/provenance"
+	"github.com/plumber-cd/runtainer/spec"
+	"github.com/plumber-cd/runtainer/volumes"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	// the CLI flags are not bound when used as a library, settings must default to the same values regardless
+	config.SetDefaults()
+}
+
+// Options for the run
+type Options struct {
+	// Image to run, required
+	Image string
+	// Command and Args for the container, the image defaults are used if empty
+	Command []string
+	Args    []string
+	// Stdin, Stdout and Stderr default to the standard streams.
+	// Stdin is only connected with the stdin setting enabled, which is the default same as for the CLI.
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+	// KubeConfig defaults to the in-cluster config or local kubeconfig
+	KubeConfig *rest.Config
+	// Namespace defaults to the namespace from the kube config
+	Namespace string
+	// Settings for this run, keys are the same as in the config file, i.e. volumes or dry-run.
+	// Previous values of these keys are restored after the run.
+	Settings map[string]interface{}
+}
+
+// Result of the run
+type Result struct {
+	// ExitCode of the container, -1 if it is unknown, i.e. the run failed before the container exited
+	ExitCode int
+	// Spec is what was discovered, nil if the discovery failed
+	Spec *spec.RunSpec
+	// PodName and Namespace of the pod, empty if the discovery failed
+	PodName   string
+	Namespace string
+	// Pod as it was last observed, nil if it wasn't created
+	Pod *v1.Pod
+	// ImageID as reported by the cluster, i.e. with the resolved digest
+	ImageID string
+}
+
+// Run discovers everything and runs the container in the pod.
+// Container exiting with non-zero code is not an error, see Result.ExitCode.
+// Cancelling the context deletes the pod.
+func Run(ctx context.Context, opts Options) (result Result, err error) {
+	result.ExitCode = -1
+
+	if log.Normal == nil {
+		log.SetupLog()
+	}
+	// some of the deep internals still panic on what is never expected to happen
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("runtainer panic: %v", r)
+		}
+	}()
+
+	if opts.Image == "" {
+		return result, failure.Errorf(failure.Config, "image is required")
+	}
+
+	defer applySettings(opts.Settings)()
+	if opts.KubeConfig != nil || opts.Namespace != "" {
+		host.SetKubeConfig(opts.KubeConfig, opts.Namespace)
+		defer host.SetKubeConfig(nil, "")
+	}
+
+	s, err := Discover(ctx, opts.Image, image.DiscoverImage)
+	if err != nil {
+		return result, err
+	}
+	result.Spec = s
+
+	// just for debugging, dump full viper data and the spec before passing it to the backends
+	if allSettings, err := json.MarshalIndent(viper.AllSettings(), "", "  "); err == nil {
+		log.Debug.Printf("Settings: %s", string(allSettings))
+	}
+	if runSpec, err := json.MarshalIndent(s, "", "  "); err == nil {
+		log.Debug.Printf("Spec: %s", string(runSpec))
+	}
+
+	streams := k8s.Streams{Stdin: opts.Stdin, Stdout: opts.Stdout, Stderr: opts.Stderr}
+	podOptions, err := k8s.Run(ctx, s, streams, opts.Command, opts.Args)
+	return NewResult(s, podOptions, err)
+}
+
+// applySettings applies settings to viper and returns a func that puts back what was there before.
+// Viper can't unset a key, so the keys that were not set before are set to nil, which viper treats as not set.
+func applySettings(settings map[string]interface{}) func() {
+	previous := make(map[string]interface{}, len(settings))
+	for key, val := range settings {
+		previous[key] = nil
+		if viper.IsSet(key) {
+			previous[key] = viper.Get(key)
+		}
+		viper.Set(key, val)
+	}
+	return func() {
+		for key, val := range previous {
+			viper.Set(key, val)
+		}
+	}
+}
+
+// Discover runs every discovery routine for the image and returns the validated spec.
+// discoverImage is either image.DiscoverImage to probe the image or image.AssumeImage to skip it.
+func Discover(ctx context.Context, imageName string, discoverImage func(context.Context, string) (image.Image, error)) (*spec.RunSpec, error) {
+	log.SetPhase("discovery")
+	log.Debug.Print("Start discovery routine")
+	provenance.Reset()
+
+	s := spec.New()
+	var err error
+
+	if s.Host, err = host.DiscoverHost(); err != nil {
+		return nil, err
+	}
+	if s.Image, err = discoverImage(ctx, imageName); err != nil {
+		return nil, err
+	}
+	if s.Env, err = env.DiscoverEnv(s.Host, s.Image); err != nil {
+		return nil, err
+	}
+	if s.Ports, err = env.DiscoverPorts(); err != nil {
+		return nil, err
+	}
+	if s.Volumes, err = volumes.DiscoverVolumes(s.Host, s.Image); err != nil {
+		return nil, err
+	}
+
+	if err := discover.DiscoverAll(s); err != nil {
+		return nil, err
+	}
+
+	if err := s.Env.ApplyRules(); err != nil {
+		return nil, err
+	}
+
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// NewResult makes the result out of what the backend returned.
+// Container exit code is moved into the result, any other error is returned as-is.
+func NewResult(s *spec.RunSpec, podOptions *host.PodOptions, runErr error) (Result, error) {
+	result := Result{ExitCode: -1, Spec: s}
+
+	if podOptions != nil {
+		result.PodName = podOptions.PodSpec.ObjectMeta.Name
+		result.Namespace = podOptions.Namespace
+		result.Pod = podOptions.Pod
+		if pod := podOptions.Pod; pod != nil {
+			for _, status := range pod.Status.ContainerStatuses {
+				if status.Name == podOptions.Container {
+					result.ImageID = status.ImageID
+				}
+			}
+		}
+	}
+
+	if runErr != nil {
+		if e, ok := runErr.(exec.CodeExitError); ok {
+			result.ExitCode = e.ExitStatus()
+			return result, nil
+		}
+		return result, runErr
+	}
+
+	result.ExitCode = 0
+	return result, nil
+}
@@ -0,0 +1,100 @@
+// Package spec defines RunSpec - everything discovered about the run.
+// It flows from discovery to the backend, so neither of them has to look the facts up in viper.
+package spec
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/plumber-cd/runtainer/env"
+	"github.com/plumber-cd/runtainer/failure"
+	"github.com/plumber-cd/runtainer/host"
+	"github.com/plumber-cd/runtainer/image"
+	"github.com/plumber-cd/runtainer/volumes"
+)
+
+// RunSpec is what to run and how, as discovered from the host, the image, config and flags
+type RunSpec struct {
+	Host    host.Host
+	Image   image.Image
+	Env     env.Env
+	Ports   env.Ports
+	Volumes volumes.Volumes
+	// Secrets to inject on top of --secret-env and --secret-volume, i.e. added by discovery plugins
+	Secrets Secrets
+	// PodPatches are strategic merge patches applied to the pod, in order
+	PodPatches []json.RawMessage
+}
+
+// Secrets in the same format as --secret-env and --secret-volume
+type Secrets struct {
+	Env     []string
+	Volumes []string
+}
+
+// New returns an empty spec ready to be discovered into
+func New() *RunSpec {
+	return &RunSpec{
+		Env:   env.Env{},
+		Ports: env.Ports{},
+		Volumes: volumes.Volumes{
+			HostMapping: []volumes.Volume{},
+		},
+	}
+}
+
+// Validate checks that the spec is complete and consistent, so that the backend could use it as-is
+func (s *RunSpec) Validate() error {
+	problems := []string{}
+	add := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if s.Image.Name == "" {
+		add("image name is empty")
+	}
+	if s.Host.Cwd == "" {
+		add("host cwd is empty")
+	}
+	if s.Volumes.ContainerCwd == "" {
+		add("container cwd is empty")
+	}
+
+	for key, val := range s.Env {
+		if key == "" {
+			add("env variable with empty name")
+		}
+		switch val.(type) {
+		case nil, string, env.SecretKeyRef:
+		default:
+			add("env variable %s has unsupported value type %T", key, val)
+		}
+	}
+
+	for local, remote := range s.Ports {
+		if local < 1 || local > 65535 || remote < 1 || remote > 65535 {
+			add("port %d:%d is out of range", local, remote)
+		}
+	}
+
+	for _, v := range s.Volumes.HostMapping {
+		if v.Src == "" || v.Dest == "" {
+			add("volume %s:%s must have both src and dest", v.Src, v.Dest)
+		}
+	}
+
+	for _, p := range s.PodPatches {
+		if !json.Valid(p) {
+			add("pod patch is not a valid JSON: %s", string(p))
+		}
+	}
+
+	if len(problems) > 0 {
+		// maps are iterated in random order, keep the message stable
+		sort.Strings(problems)
+		return failure.Errorf(failure.Config, "invalid run spec: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
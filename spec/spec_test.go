@@ -0,0 +1,122 @@
+package spec
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/plumber-cd/runtainer/env"
+	"github.com/plumber-cd/runtainer/failure"
+	"github.com/plumber-cd/runtainer/volumes"
+)
+
+// valid returns the minimal spec that passes validation
+func valid() *RunSpec {
+	s := New()
+	s.Image.Name = "alpine"
+	s.Host.Cwd = "/work"
+	s.Volumes.ContainerCwd = "/work"
+	return s
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(s *RunSpec)
+		want   string
+	}{
+		{
+			name:   "valid",
+			modify: func(s *RunSpec) {},
+		},
+		{
+			name: "valid with everything",
+			modify: func(s *RunSpec) {
+				s.Env["FOO"] = "bar"
+				s.Env["MIRRORED"] = nil
+				s.Env["FROM_SECRET"] = env.SecretKeyRef{Name: "secret", Key: "key"}
+				s.Ports[8080] = 80
+				s.Volumes.HostMapping = append(s.Volumes.HostMapping, volumes.Volume{Src: "/src", Dest: "/dest"})
+				s.PodPatches = append(s.PodPatches, json.RawMessage(`{"spec":{}}`))
+			},
+		},
+		{
+			name:   "empty image name",
+			modify: func(s *RunSpec) { s.Image.Name = "" },
+			want:   "image name is empty",
+		},
+		{
+			name:   "empty host cwd",
+			modify: func(s *RunSpec) { s.Host.Cwd = "" },
+			want:   "host cwd is empty",
+		},
+		{
+			name:   "empty container cwd",
+			modify: func(s *RunSpec) { s.Volumes.ContainerCwd = "" },
+			want:   "container cwd is empty",
+		},
+		{
+			name:   "empty env name",
+			modify: func(s *RunSpec) { s.Env[""] = "bar" },
+			want:   "env variable with empty name",
+		},
+		{
+			name:   "unsupported env value",
+			modify: func(s *RunSpec) { s.Env["FOO"] = 42 },
+			want:   "env variable FOO has unsupported value type int",
+		},
+		{
+			name:   "local port out of range",
+			modify: func(s *RunSpec) { s.Ports[0] = 80 },
+			want:   "port 0:80 is out of range",
+		},
+		{
+			name:   "remote port out of range",
+			modify: func(s *RunSpec) { s.Ports[8080] = 65536 },
+			want:   "port 8080:65536 is out of range",
+		},
+		{
+			name: "volume without dest",
+			modify: func(s *RunSpec) {
+				s.Volumes.HostMapping = append(s.Volumes.HostMapping, volumes.Volume{Src: "/src"})
+			},
+			want: "volume /src: must have both src and dest",
+		},
+		{
+			name:   "invalid pod patch",
+			modify: func(s *RunSpec) { s.PodPatches = append(s.PodPatches, json.RawMessage(`{"spec":`)) },
+			want:   `pod patch is not a valid JSON: {"spec":`,
+		},
+		{
+			name: "problems are sorted",
+			modify: func(s *RunSpec) {
+				s.Image.Name = ""
+				s.Host.Cwd = ""
+			},
+			want: "invalid run spec: host cwd is empty; image name is empty",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := valid()
+			tt.modify(s)
+			err := s.Validate()
+			if tt.want == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("expected error containing %q", tt.want)
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error %q doesn't contain %q", err, tt.want)
+			}
+			if kind := failure.KindOf(err); kind != failure.Config {
+				t.Errorf("expected %s, got %s", failure.Config, kind)
+			}
+		})
+	}
+}
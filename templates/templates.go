@@ -0,0 +1,105 @@
+// Package templates expands Go templates in config values using discovered host and image facts.
+package templates
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"text/template"
+
+	"github.com/plumber-cd/runtainer/config"
+	"github.com/plumber-cd/runtainer/failure"
+	"github.com/plumber-cd/runtainer/host"
+	"github.com/plumber-cd/runtainer/image"
+	"github.com/plumber-cd/runtainer/log"
+)
+
+// Git is the metadata of the git repository in the host cwd.
+// All fields are empty if cwd is not in a git repository or git is not installed.
+type Git struct {
+	Branch      string
+	Commit      string
+	ShortCommit string
+	Root        string
+	Remote      string
+}
+
+// Data is what's available in the templates
+type Data struct {
+	Host  host.Host
+	Image image.Image
+	Env   map[string]string
+
+	gitOnce sync.Once
+	git     Git
+}
+
+// Git is only called when the template references it, as it runs git commands on the host
+func (d *Data) Git() Git {
+	d.gitOnce.Do(func() {
+		run := func(args ...string) string {
+			cmd := exec.Command("git", args...)
+			cmd.Dir = d.Host.Cwd
+			out, err := cmd.Output()
+			if err != nil {
+				log.Debug.Printf("git %s: %s", strings.Join(args, " "), err)
+				return ""
+			}
+			return strings.TrimSpace(string(out))
+		}
+		d.git = Git{
+			Branch:      run("rev-parse", "--abbrev-ref", "HEAD"),
+			Commit:      run("rev-parse", "HEAD"),
+			ShortCommit: run("rev-parse", "--short", "HEAD"),
+			Root:        run("rev-parse", "--show-toplevel"),
+			Remote:      run("config", "--get", "remote.origin.url"),
+		}
+		log.Debug.Printf("Git facts: %+v", d.git)
+	})
+	return d.git
+}
+
+// NewData collects facts for templates, host env is taken as-is
+func NewData(h host.Host, i image.Image) *Data {
+	d := &Data{
+		Host:  h,
+		Image: i,
+		Env:   map[string]string{},
+	}
+	for _, kv := range os.Environ() {
+		split := strings.SplitN(kv, "=", 2)
+		d.Env[split[0]] = split[1]
+	}
+	return d
+}
+
+// Expand executes s as a template, key is the setting it came from for the error messages.
+// Strings without {{ are returned as-is.
+func (d *Data) Expand(key, s string) (string, error) {
+	if !strings.Contains(s, "{{") {
+		return s, nil
+	}
+
+	// template error messages already include the name, so only the file is needed on top
+	fail := func(err error) (string, error) {
+		if src := config.Source(key); src != "" {
+			return "", failure.Errorf(failure.Config, "%s: %w", src, err)
+		}
+		return "", failure.Wrap(failure.Config, err)
+	}
+
+	t, err := template.New(key).Option("missingkey=zero").Parse(s)
+	if err != nil {
+		return fail(err)
+	}
+
+	var b bytes.Buffer
+	if err := t.Execute(&b, d); err != nil {
+		return fail(err)
+	}
+
+	log.Debug.Printf("Expanded %s: %s -> %s", key, s, b.String())
+	return b.String(), nil
+}
@@ -0,0 +1,70 @@
+package utils
+
+import (
+	"bytes"
+	"io"
+	"sync"
+)
+
+// PrefixWriter prefixes every line written through it.
+// Multiple writers can share the same mutex so that the lines from different sources are not interleaved.
+type PrefixWriter struct {
+	mutex  *sync.Mutex
+	writer io.Writer
+	prefix []byte
+	// bufMutex guards buf, Write and Flush might be called from different goroutines
+	bufMutex sync.Mutex
+	buf      []byte
+}
+
+// NewPrefixWriter creates a new PrefixWriter
+func NewPrefixWriter(w io.Writer, mutex *sync.Mutex, prefix string) *PrefixWriter {
+	return &PrefixWriter{
+		mutex:  mutex,
+		writer: w,
+		prefix: []byte(prefix),
+	}
+}
+
+// Write buffers p and writes out every complete line with the prefix
+func (p *PrefixWriter) Write(b []byte) (int, error) {
+	p.bufMutex.Lock()
+	defer p.bufMutex.Unlock()
+
+	p.buf = append(p.buf, b...)
+	for {
+		i := bytes.IndexByte(p.buf, '\n')
+		if i < 0 {
+			break
+		}
+		if err := p.writeLine(p.buf[:i+1]); err != nil {
+			return len(b), err
+		}
+		p.buf = p.buf[i+1:]
+	}
+	return len(b), nil
+}
+
+// Flush writes out whatever incomplete line is left in the buffer
+func (p *PrefixWriter) Flush() error {
+	p.bufMutex.Lock()
+	defer p.bufMutex.Unlock()
+
+	if len(p.buf) == 0 {
+		return nil
+	}
+	line := append(p.buf, '\n')
+	p.buf = nil
+	return p.writeLine(line)
+}
+
+func (p *PrefixWriter) writeLine(line []byte) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if _, err := p.writer.Write(p.prefix); err != nil {
+		return err
+	}
+	_, err := p.writer.Write(line)
+	return err
+}
@@ -22,7 +22,7 @@ func resolveTilde(h, p string) string {
 	return p
 }
 
-func checkLocalDir(h host.Host, dc DiscoveryConfig, path string) (bool, string) {
+func checkLocalDir(h host.Host, dc DiscoveryConfig, path string) (bool, string, error) {
 	path = resolveTilde(h.Home, path)
 
 	if dc.UseParent {
@@ -33,17 +33,17 @@ func checkLocalDir(h host.Host, dc DiscoveryConfig, path string) (bool, string)
 	// do that only for src as filepath uses host file separator
 	p, err := filepath.Abs(path)
 	if err != nil {
-		log.Normal.Panic(err)
+		return false, "", err
 	}
 
 	exists, err := utils.OsFs.DirExists(p)
 	if err != nil {
-		log.Normal.Panic(err)
+		return false, "", err
 	}
 	if !exists {
 		log.Debug.Printf("Volume source %s didn't existed on the host, skipping...", p)
-		return false, ""
+		return false, "", nil
 	}
 
-	return true, p
+	return true, p, nil
 }
@@ -9,9 +9,13 @@ import (
 	"strings"
 
 	"github.com/mitchellh/mapstructure"
+	"github.com/plumber-cd/runtainer/config"
+	"github.com/plumber-cd/runtainer/failure"
 	"github.com/plumber-cd/runtainer/host"
 	"github.com/plumber-cd/runtainer/image"
 	"github.com/plumber-cd/runtainer/log"
+	"github.com/plumber-cd/runtainer/provenance"
+	"github.com/plumber-cd/runtainer/templates"
 	"github.com/spf13/viper"
 )
 
@@ -32,7 +36,7 @@ func (dc *DiscoveryConfig) string() string {
 // Discover is an interface for various discoverers
 type Discover interface {
 	// Discover must return true only if discovered, validated and ensured it is usable
-	discover(h host.Host, i image.Image, dest string) (found bool, src string)
+	discover(h host.Host, i image.Image, dest string) (found bool, src string, err error)
 }
 
 // DiscoverMirror basically is a default fallback discoverer.
@@ -46,7 +50,7 @@ func (d *DiscoverMirror) string() string {
 	return fmt.Sprintf("DiscoverMirror{} with %s", d.Config.string())
 }
 
-func (d *DiscoverMirror) discover(h host.Host, _ image.Image, dest string) (bool, string) {
+func (d *DiscoverMirror) discover(h host.Host, _ image.Image, dest string) (bool, string, error) {
 	log.Debug.Printf("%s, dest: %s", d.string(), dest)
 	return checkLocalDir(h, d.Config, dest)
 }
@@ -62,7 +66,7 @@ func (d *DiscoverDir) string() string {
 	return fmt.Sprintf("DiscoverDir{Path: %s} with %s", d.Path, d.Config.string())
 }
 
-func (d *DiscoverDir) discover(h host.Host, _ image.Image, dest string) (bool, string) {
+func (d *DiscoverDir) discover(h host.Host, _ image.Image, dest string) (bool, string, error) {
 	log.Debug.Printf("%s", d.string())
 	return checkLocalDir(h, d.Config, d.Path)
 }
@@ -78,12 +82,12 @@ func (d *DiscoverEnvVar) string() string {
 	return fmt.Sprintf("DiscoverEnvVar{EnvVar: %s} with %s", d.EnvVar, d.Config.string())
 }
 
-func (d *DiscoverEnvVar) discover(h host.Host, _ image.Image, _ string) (bool, string) {
+func (d *DiscoverEnvVar) discover(h host.Host, _ image.Image, _ string) (bool, string, error) {
 	log.Debug.Printf("%s", d.string())
 	src, exists := os.LookupEnv(d.EnvVar)
 	if !exists {
 		log.Debug.Printf("%s variable was not found", d.EnvVar)
-		return false, ""
+		return false, "", nil
 	}
 	log.Debug.Printf("Found %s=%s", d.EnvVar, src)
 	return checkLocalDir(h, d.Config, src)
@@ -100,15 +104,18 @@ func (d *DiscoverExec) string() string {
 	return fmt.Sprintf("DiscoverExec{Args: [%s]} with %s", strings.Join(d.Args, ", "), d.Config.string())
 }
 
-func (d *DiscoverExec) discover(h host.Host, _ image.Image, _ string) (bool, string) {
+func (d *DiscoverExec) discover(h host.Host, _ image.Image, _ string) (bool, string, error) {
 	log.Debug.Printf("%s", d.string())
 	bin, err := exec.LookPath(d.Args[0])
 	if bin == "" || err != nil {
 		log.Debug.Printf("%s binary was not found (%s)", d.Args[0], err)
-		return false, ""
+		return false, "", nil
 	}
 	log.Debug.Printf("Found binary %s", bin)
-	src := host.Exec(exec.Command(bin, d.Args[1:]...))
+	src, err := host.Exec(exec.Command(bin, d.Args[1:]...))
+	if err != nil {
+		return false, "", err
+	}
 	return checkLocalDir(h, d.Config, src)
 }
 
@@ -123,12 +130,12 @@ func (d *DiscoverCallback) string() string {
 	return fmt.Sprintf("DiscoverCallback{Callback: <...>} with %s", d.Config.string())
 }
 
-func (d *DiscoverCallback) discover(h host.Host, i image.Image, dest string) (bool, string) {
+func (d *DiscoverCallback) discover(h host.Host, i image.Image, dest string) (bool, string, error) {
 	log.Debug.Printf("%s, dest: %s", d.string(), dest)
 	exists, src := d.Callback(h, i, dest)
 	if !exists {
 		log.Debug.Printf("Callback did not found any source (%s)", src)
-		return exists, src
+		return exists, src, nil
 	}
 	log.Debug.Printf("Callback returned %s", src)
 	return checkLocalDir(h, d.Config, src)
@@ -153,10 +160,23 @@ type Volumes struct {
 // Try each source until something found, if reached the end and nothing found - do nothing.
 // Only for mounting directories.
 // Automatically resolves ~ to the user home (both host and container).
-func (v *Volumes) AddHostMount(h host.Host, i image.Image, dest string, sources ...Discover) {
+func (v *Volumes) AddHostMount(h host.Host, i image.Image, dest string, sources ...Discover) error {
+	added, err := v.AddHostMountUntracked(h, i, dest, sources...)
+	if added != "" {
+		provenance.Add(provenance.KindVolume, added)
+	}
+	return err
+}
+
+// AddHostMountUntracked is AddHostMount that leaves recording the provenance to the caller,
+// returns the container path of the added mount or empty string if nothing was found.
+func (v *Volumes) AddHostMountUntracked(h host.Host, i image.Image, dest string, sources ...Discover) (string, error) {
 	log.Debug.Printf("Discovering potential volume mount for %s", dest)
 	for _, source := range sources {
-		found, src := source.discover(h, i, dest)
+		found, src, err := source.discover(h, i, dest)
+		if err != nil {
+			return "", failure.Wrap(failure.Discovery, fmt.Errorf("volume %s: %w", dest, err))
+		}
 		if found {
 			log.Debug.Printf("Match found %s:%s", src, dest)
 			if dest == "" {
@@ -165,14 +185,16 @@ func (v *Volumes) AddHostMount(h host.Host, i image.Image, dest string, sources
 			dest = resolveTilde(i.Home, dest)
 			v.HostMapping = append(v.HostMapping, Volume{Src: src, Dest: dest})
 			log.Debug.Printf("Added volume %s:%s", src, dest)
-			return
+			return dest, nil
 		}
 	}
 	log.Debug.Printf("Nothing found for volume mount %s", dest)
+
+	return "", nil
 }
 
 // DiscoverVolumes analyze environment to determine what to mount
-func DiscoverVolumes() {
+func DiscoverVolumes(h host.Host, i image.Image) (Volumes, error) {
 	log.Debug.Print("Discover volumes")
 
 	volumes := Volumes{}
@@ -182,9 +204,8 @@ func DiscoverVolumes() {
 		log.Debug.Print("Load user defined volumes settings")
 		// when read from viper for the first time (i.e. nothing Set it there yet as the struct) it will be a map[string]interface{}
 		// hence we need to convert it to the struct
-		err := mapstructure.Decode(v, &volumes)
-		if err != nil {
-			log.Normal.Panic(err)
+		if err := mapstructure.Decode(v, &volumes); err != nil {
+			return volumes, failure.Errorf(failure.Config, "%s: %w", config.Describe("volumes"), err)
 		}
 	}
 
@@ -193,9 +214,17 @@ func DiscoverVolumes() {
 		volumes.HostMapping = make([]Volume, 0)
 	}
 
-	// Host and image should have been already analyzed by this point
-	h := viper.Get("host").(host.Host)
-	i := viper.Get("image").(image.Image)
+	t := templates.NewData(h, i)
+	for idx := range volumes.HostMapping {
+		var err error
+		if volumes.HostMapping[idx].Src, err = t.Expand("volumes.hostMapping", volumes.HostMapping[idx].Src); err != nil {
+			return volumes, err
+		}
+		if volumes.HostMapping[idx].Dest, err = t.Expand("volumes.hostMapping", volumes.HostMapping[idx].Dest); err != nil {
+			return volumes, err
+		}
+		provenance.AddWithOrigin(provenance.KindVolume, volumes.HostMapping[idx].Dest, provenance.Setting("volumes.hostMapping"))
+	}
 
 	// first of all, add mount for the host home
 	// use path and not filepath as host path separator is irrelevant to what's inside the container
@@ -204,17 +233,24 @@ func DiscoverVolumes() {
 		Src:  h.Home,
 		Dest: hostHomeMount,
 	})
+	provenance.AddWithOrigin(provenance.KindVolume, hostHomeMount, "runtainer (host home)")
 
 	for _, vol := range viper.GetStringSlice("volume") {
 		log.Debug.Printf("Parsing --volume=%s", vol)
 		volSplit := strings.Split(vol, ":")
 		if len(volSplit) != 2 {
-			log.Normal.Fatalf("Invalid input for --volume=%s", vol)
+			return volumes, failure.Errorf(failure.Config, "Invalid input for --volume=%s", vol)
+		}
+		src, err := t.Expand("volume", volSplit[0])
+		if err != nil {
+			return volumes, err
+		}
+		dest, err := t.Expand("volume", volSplit[1])
+		if err != nil {
+			return volumes, err
 		}
-		volumes.HostMapping = append(volumes.HostMapping, Volume{
-			Src:  volSplit[0],
-			Dest: volSplit[1],
-		})
+		volumes.HostMapping = append(volumes.HostMapping, Volume{Src: src, Dest: dest})
+		provenance.AddWithOrigin(provenance.KindVolume, volumes.HostMapping[len(volumes.HostMapping)-1].Dest, provenance.Setting("volume"))
 	}
 
 	// now we will determine current working directory inside
@@ -223,7 +259,7 @@ func DiscoverVolumes() {
 		// basically, if current working directory on the host somewhere under the user home, we already have it mounted - we just need to calculate the path to it
 		containerRtHomePath, err := filepath.Rel(h.Home, h.Cwd)
 		if err != nil {
-			log.Normal.Panic(err)
+			return volumes, failure.Wrap(failure.Discovery, err)
 		}
 		// convert path separator to what's in the image
 		// note that filepath.FromSlash and filepath.ToSlash won't work as they would rely on the host OS file separator
@@ -233,7 +269,7 @@ func DiscoverVolumes() {
 		case "/":
 			containerRtHomePath = strings.ReplaceAll(containerRtHomePath, "\\", "/")
 		default:
-			log.Normal.Fatalf("Unknown path separator: %s", i.PathSeparator)
+			return volumes, failure.Errorf(failure.Image, "Unknown path separator: %s", i.PathSeparator)
 		}
 		// again, this is for the container so host path separator is irrelevant, hence path not filepath
 		volumes.ContainerCwd = path.Join(hostHomeMount, containerRtHomePath)
@@ -246,8 +282,8 @@ func DiscoverVolumes() {
 		volumes.ContainerCwd = path.Join(i.Home, rtCwd, containerRtHomePath)
 		// and add it to mounts
 		volumes.HostMapping = append(volumes.HostMapping, Volume{Src: h.Cwd, Dest: volumes.ContainerCwd})
+		provenance.AddWithOrigin(provenance.KindVolume, volumes.ContainerCwd, "runtainer (cwd)")
 	}
 
-	log.Debug.Print("Publish to viper")
-	viper.Set("volumes", volumes)
+	return volumes, nil
 }